@@ -0,0 +1,393 @@
+package elasticmapper
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BulkOptions controls how a BulkEncoder writes documents.
+type BulkOptions struct {
+	// Index is the _index of every action. Leave it empty to give the index
+	// in the URL of the bulk request instead.
+	Index string
+
+	// UseGoNames writes the fields of documents under their Go names, as
+	// Options.UseGoNames maps them. By default documents are written with
+	// encoding/json.
+	UseGoNames bool
+
+	// MaxActions and MaxBytes limit the size of a batch. A batch is flushed
+	// before an action that would take it over either limit. Zero means no
+	// limit. ElasticSearch rejects requests over http.max_content_length,
+	// which defaults to 100MB, but batches of a few MB index faster.
+	MaxActions int
+	MaxBytes   int
+
+	// DocAsUpsert makes updates index the document if it doesn't exist yet.
+	DocAsUpsert bool
+}
+
+// BulkEncoder writes documents as actions of bulk requests, in the
+// newline-delimited JSON the _bulk API takes, and hands them out in batches.
+// The _id and routing of each action come from the fields tagged _id and
+// _routing:
+//
+//	type Thing struct {
+//		ID     string `json:"-" elasticmapper:"_id"`
+//		Tenant string `json:"tenant" elasticmapper:"keyword,_routing"`
+//	}
+//
+// Documents without an _id field, or with a zero one, get an _id generated
+// by ElasticSearch when they're indexed or created. A BulkEncoder is not safe
+// for concurrent use.
+type BulkEncoder struct {
+	opts  BulkOptions
+	flush func(batch []byte, actions int) error
+	types map[reflect.Type]*bulkType
+
+	buf     bytes.Buffer
+	actions int
+}
+
+// NewBulkEncoder returns a BulkEncoder that calls flush with every batch of
+// actions, the body of one bulk request. The batch is only valid during the
+// call. An error from flush is returned by the call that caused the flush, and
+// the batch is kept, so calling Flush again retries it. An action whose add
+// caused a failed flush isn't added.
+func NewBulkEncoder(opts BulkOptions, flush func(batch []byte, actions int) error) *BulkEncoder {
+	return &BulkEncoder{opts: opts, flush: flush, types: make(map[reflect.Type]*bulkType)}
+}
+
+// bulkType is where the _id and routing of a struct type are.
+type bulkType struct {
+	id, routing []int
+}
+
+// Index adds an index action for doc, which replaces any document with the
+// same _id.
+func (e *BulkEncoder) Index(doc interface{}) error {
+	source, err := e.document(doc)
+	if err != nil {
+		return err
+	}
+	return e.add("index", doc, source)
+}
+
+// Create adds a create action for doc, which fails if a document with the
+// same _id exists.
+func (e *BulkEncoder) Create(doc interface{}) error {
+	source, err := e.document(doc)
+	if err != nil {
+		return err
+	}
+	return e.add("create", doc, source)
+}
+
+// Update adds an update action for doc, which changes the fields of the
+// existing document to those of doc. Fields left out of the JSON of doc,
+// like those with omitempty, are left alone.
+func (e *BulkEncoder) Update(doc interface{}) error {
+	source, err := e.document(doc)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{"doc": json.RawMessage(source)}
+	if e.opts.DocAsUpsert {
+		body["doc_as_upsert"] = true
+	}
+	if source, err = json.Marshal(body); err != nil {
+		return err
+	}
+	return e.add("update", doc, source)
+}
+
+// Delete adds a delete action for the document with the _id and routing of
+// doc.
+func (e *BulkEncoder) Delete(doc interface{}) error {
+	return e.add("delete", doc, nil)
+}
+
+// Flush hands out the actions added since the last batch, if any. If the
+// flush function fails, the actions stay in the encoder for the next Flush.
+func (e *BulkEncoder) Flush() error {
+	if e.actions == 0 {
+		return nil
+	}
+	if err := e.flush(e.buf.Bytes(), e.actions); err != nil {
+		return err
+	}
+	e.buf.Reset()
+	e.actions = 0
+	return nil
+}
+
+// add writes an action on doc followed by source, which is nil for delete
+// actions.
+func (e *BulkEncoder) add(action string, doc interface{}, source []byte) error {
+	meta, err := e.metadata(action, doc)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(map[string]interface{}{action: meta})
+	if err != nil {
+		return err
+	}
+	item := append(line, '\n')
+	if source != nil {
+		item = append(append(item, source...), '\n')
+	}
+
+	if e.opts.MaxBytes > 0 && len(item) > e.opts.MaxBytes {
+		return fmt.Errorf("elasticmapper: %s action of %d bytes is over the batch limit of %d bytes", action, len(item), e.opts.MaxBytes)
+	}
+	if e.opts.MaxActions > 0 && e.actions >= e.opts.MaxActions ||
+		e.opts.MaxBytes > 0 && e.buf.Len()+len(item) > e.opts.MaxBytes {
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+	e.buf.Write(item)
+	e.actions++
+	return nil
+}
+
+// metadata returns the metadata of an action on doc.
+func (e *BulkEncoder) metadata(action string, doc interface{}) (map[string]string, error) {
+	meta := make(map[string]string)
+	if e.opts.Index != "" {
+		meta["_index"] = e.opts.Index
+	}
+	v := reflect.ValueOf(doc)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, errors.New("elasticmapper: can't add a nil document to a bulk request")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		bt, err := e.bulkType(v.Type())
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range []struct {
+			key   string
+			index []int
+		}{{"_id", bt.id}, {"routing", bt.routing}} {
+			if f.index == nil {
+				continue
+			}
+			s, err := metadataValue(v, f.index)
+			if err != nil {
+				return nil, fmt.Errorf("elasticmapper: %s of %s: %v", f.key, v.Type(), err)
+			}
+			if s != "" {
+				meta[f.key] = s
+			}
+		}
+	}
+	if meta["_id"] == "" && (action == "update" || action == "delete") {
+		return nil, fmt.Errorf("elasticmapper: %s actions need an _id, and %T has none", action, doc)
+	}
+	return meta, nil
+}
+
+// bulkType finds the fields tagged _id and _routing of struct type t. They
+// may be left out of the document with `json:"-"`.
+func (e *BulkEncoder) bulkType(t reflect.Type) (*bulkType, error) {
+	if bt, ok := e.types[t]; ok {
+		return bt, nil
+	}
+	bt := &bulkType{}
+	fields := typeFields(t, false)
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.Tag.Get("json") == "-" {
+			fields = append(fields, field{name: sf.Name, index: sf.Index, sf: sf})
+		}
+	}
+	for _, f := range fields {
+		opts, err := parseTag(f.sf.Tag.Get(structTag))
+		if err != nil {
+			return nil, &FieldError{Path: f.name, Type: f.sf.Type, Reason: err.Error()}
+		}
+		for _, marked := range []struct {
+			set   bool
+			index *[]int
+			name  string
+		}{{opts.ID, &bt.id, "_id"}, {opts.Routing, &bt.routing, "_routing"}} {
+			if !marked.set {
+				continue
+			}
+			if *marked.index != nil {
+				return nil, &FieldError{Path: f.name, Type: f.sf.Type, Reason: fmt.Sprintf("%s has more than one field tagged %s", t, marked.name)}
+			}
+			*marked.index = f.index
+		}
+	}
+	e.types[t] = bt
+	return bt, nil
+}
+
+// metadataValue returns the field of v at index as the string used for an
+// _id or routing. An empty string means there's none: the field is zero, or
+// there's a nil pointer on the way.
+func metadataValue(v reflect.Value, index []int) (string, error) {
+	for _, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return "", nil
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if isEmptyValue(v) {
+		return "", nil
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("%s can't be an id, only strings, integers and text marshalers can", v.Type())
+}
+
+// document returns the JSON of doc, the source of an index or create action.
+// Bulk requests are split on newlines, which encoding/json never writes.
+func (e *BulkEncoder) document(doc interface{}) ([]byte, error) {
+	if !e.opts.UseGoNames {
+		return json.Marshal(doc)
+	}
+	return json.Marshal(goNamesValue(reflect.ValueOf(doc)))
+}
+
+// goNamesValue returns v with its structs turned into maps keyed by Go field
+// names, for encoding/json to write. Values with JSON or text marshalers are
+// left alone.
+func goNamesValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return goNamesValue(v.Elem())
+	case reflect.Struct:
+		if reflect.PtrTo(v.Type()).Implements(jsonMarshalerType) || reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+			// Marshaled through a pointer by encoding/json
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			return p.Interface()
+		}
+		object := make(map[string]interface{})
+		for _, f := range typeFields(v.Type(), true) {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok {
+				continue
+			}
+			if omitEmpty(f.sf.Tag.Get("json")) && isEmptyValue(fv) {
+				continue
+			}
+			object[f.name] = goNamesValue(fv)
+		}
+		return object
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		if isByteSlice(v.Type()) {
+			return v.Interface()
+		}
+		elements := make([]interface{}, v.Len())
+		for i := range elements {
+			elements[i] = goNamesValue(v.Index(i))
+		}
+		return elements
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			// encoding/json knows what to do with other keys
+			return v.Interface()
+		}
+		object := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			object[iter.Key().String()] = goNamesValue(iter.Value())
+		}
+		return object
+	}
+	return v.Interface()
+}
+
+// fieldByIndex is v.FieldByIndex, except that it returns false for fields of
+// nil embedded pointers instead of panicking.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for n, i := range index {
+		if n > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// omitEmpty reports whether a json tag has the omitempty option.
+func omitEmpty(tag string) bool {
+	if i := strings.Index(tag, ","); i != -1 {
+		for _, option := range strings.Split(tag[i+1:], ",") {
+			if option == "omitempty" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEmptyValue reports whether encoding/json considers v empty for omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
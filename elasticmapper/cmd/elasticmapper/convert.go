@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"net"
+	"reflect"
+	"time"
+	"unicode"
+	"unsafe"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+// knownTypes are named types the mapper treats specially, keyed by their full
+// name. They can't be rebuilt from their structure, so we use the real thing.
+var knownTypes = map[string]reflect.Type{
+	"time.Time":                reflect.TypeOf(time.Time{}),
+	"time.Duration":            reflect.TypeOf(time.Duration(0)),
+	"net.IP":                   reflect.TypeOf(net.IP{}),
+	"encoding/json.RawMessage": reflect.TypeOf(json.RawMessage{}),
+	"encoding/json.Number":     reflect.TypeOf(json.Number("")),
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper.GeoPoint": reflect.TypeOf(elasticmapper.GeoPoint{}),
+	"github.com/wingedrhino/golang-snippets/elasticmapper.Join":     reflect.TypeOf(elasticmapper.Join{}),
+	"github.com/wingedrhino/golang-snippets/elasticmapper.Object":   objectMarkerType,
+}
+
+var basicTypes = map[types.BasicKind]reflect.Type{
+	types.Bool:          reflect.TypeOf(false),
+	types.Int:           reflect.TypeOf(int(0)),
+	types.Int8:          reflect.TypeOf(int8(0)),
+	types.Int16:         reflect.TypeOf(int16(0)),
+	types.Int32:         reflect.TypeOf(int32(0)),
+	types.Int64:         reflect.TypeOf(int64(0)),
+	types.Uint:          reflect.TypeOf(uint(0)),
+	types.Uint8:         reflect.TypeOf(uint8(0)),
+	types.Uint16:        reflect.TypeOf(uint16(0)),
+	types.Uint32:        reflect.TypeOf(uint32(0)),
+	types.Uint64:        reflect.TypeOf(uint64(0)),
+	types.Uintptr:       reflect.TypeOf(uintptr(0)),
+	types.Float32:       reflect.TypeOf(float32(0)),
+	types.Float64:       reflect.TypeOf(float64(0)),
+	types.Complex64:     reflect.TypeOf(complex64(0)),
+	types.Complex128:    reflect.TypeOf(complex128(0)),
+	types.String:        reflect.TypeOf(""),
+	types.UnsafePointer: reflect.TypeOf(unsafe.Pointer(nil)),
+}
+
+var (
+	objectMarkerType   = reflect.TypeOf(elasticmapper.Object{})
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	funcType           = reflect.TypeOf((func())(nil))
+)
+
+// converter rebuilds types found by the type checker as reflect types, so the
+// mapper can walk them without the program that defines them ever running.
+// The rebuilt types have the same fields, tags and kinds, but no methods and
+// no names.
+type converter struct {
+	done map[types.Type]reflect.Type
+	// inProgress are the named types being converted right now, to catch
+	// types that refer to themselves.
+	inProgress map[*types.Named]bool
+}
+
+func newConverter() *converter {
+	return &converter{done: make(map[types.Type]reflect.Type), inProgress: make(map[*types.Named]bool)}
+}
+
+func (c *converter) convert(t types.Type) (reflect.Type, error) {
+	if rt, ok := c.done[t]; ok {
+		return rt, nil
+	}
+	rt, err := c.convertType(t)
+	if err != nil {
+		return nil, err
+	}
+	c.done[t] = rt
+	return rt, nil
+}
+
+func (c *converter) convertType(t types.Type) (reflect.Type, error) {
+	switch t := t.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != nil {
+			if known, ok := knownTypes[obj.Pkg().Path()+"."+obj.Name()]; ok {
+				return known, nil
+			}
+		}
+		if implementsMapper(t) {
+			// The mapping comes from running the method, and the rebuilt
+			// type would be mapped by its fields instead
+			return nil, fmt.Errorf("type %s has an ElasticProperty method, which can't be called without running code", t)
+		}
+		if implementsTextMarshaler(t) {
+			// encoding/json writes these as strings, whatever they're made of
+			return reflect.TypeOf(""), nil
+		}
+		if t.TypeParams().Len() > 0 && t.TypeArgs().Len() == 0 {
+			return nil, fmt.Errorf("generic type %s needs type arguments", t)
+		}
+		if c.inProgress[t] {
+			return nil, fmt.Errorf("type %s refers to itself, which can't be rebuilt without running code", t)
+		}
+		c.inProgress[t] = true
+		defer delete(c.inProgress, t)
+		return c.convert(t.Underlying())
+	case *types.Alias:
+		return c.convert(types.Unalias(t))
+	case *types.Basic:
+		if rt, ok := basicTypes[t.Kind()]; ok {
+			return rt, nil
+		}
+		return nil, fmt.Errorf("unsupported basic type %s", t)
+	case *types.Pointer:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PtrTo(elem), nil
+	case *types.Slice:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case *types.Array:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ArrayOf(int(t.Len()), elem), nil
+	case *types.Map:
+		key, err := c.convertMapKey(t.Key())
+		if err != nil {
+			return nil, err
+		}
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case *types.Chan:
+		elem, err := c.convert(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ChanOf(reflect.BothDir, elem), nil
+	case *types.Signature:
+		return funcType, nil
+	case *types.Interface:
+		// The mapper needs a registered concrete type or a tag for
+		// interfaces anyway, and it can't tell one interface from another
+		// without running code.
+		return emptyInterfaceType, nil
+	case *types.Struct:
+		return c.convertStruct(t)
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// convertMapKey converts the key type of a map. Keys implementing
+// encoding.TextMarshaler turn into strings in JSON, which is what they become
+// here too since the rebuilt type wouldn't have the method.
+func (c *converter) convertMapKey(t types.Type) (reflect.Type, error) {
+	if implementsTextMarshaler(t) {
+		return reflect.TypeOf(""), nil
+	}
+	return c.convert(t)
+}
+
+// implementsMapper reports whether t, or a pointer to it, has the method of
+// elasticmapper.Mapper. Interfaces don't count, as they don't for Map.
+func implementsMapper(t types.Type) bool {
+	if types.IsInterface(t) {
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, "ElasticProperty")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 0 && sig.Results().Len() == 1
+}
+
+func implementsTextMarshaler(t types.Type) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, "MarshalText")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 0 && sig.Results().Len() == 2
+}
+
+func (c *converter) convertStruct(t *types.Struct) (reflect.Type, error) {
+	// Names made up for unexported fields mustn't collide with real ones,
+	// or reflect.StructOf panics
+	used := make(map[string]bool)
+	for i := 0; i < t.NumFields(); i++ {
+		if t.Field(i).Exported() {
+			used[t.Field(i).Name()] = true
+		}
+	}
+	var fields []reflect.StructField
+	for i := 0; i < t.NumFields(); i++ {
+		v := t.Field(i)
+		name := v.Name()
+		if !v.Exported() {
+			if rt, _ := c.convert(v.Type()); rt == objectMarkerType {
+				// Usually a blank field, which reflect.StructOf won't
+				// take; the mapper finds it by its type anyway
+				fields = append(fields, reflect.StructField{
+					Name: unusedName(used, fmt.Sprintf("ObjectMarker%d", i)),
+					Type: objectMarkerType,
+					Tag:  reflect.StructTag(t.Tag(i)),
+				})
+				continue
+			}
+			if !v.Embedded() {
+				// encoding/json ignores unexported fields
+				continue
+			}
+			// Fields of unexported embedded structs are still promoted,
+			// but reflect.StructOf only takes exported names.
+			underlying := v.Type()
+			if p, ok := underlying.Underlying().(*types.Pointer); ok {
+				underlying = p.Elem()
+			}
+			if _, ok := underlying.Underlying().(*types.Struct); !ok {
+				continue
+			}
+			name = unusedName(used, exportedName(name))
+		}
+		fieldType, err := c.convert(v.Type())
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", v.Name(), err)
+		}
+		fields = append(fields, reflect.StructField{
+			Name:      name,
+			Type:      fieldType,
+			Tag:       reflect.StructTag(t.Tag(i)),
+			Anonymous: v.Embedded(),
+		})
+	}
+	return reflect.StructOf(fields), nil
+}
+
+// unusedName returns name, or name with underscores appended if it's used
+// already, and marks it as used. Only the name of a field changes, since
+// JSON doesn't see the names of embedded structs and marker fields.
+func unusedName(used map[string]bool, name string) string {
+	for used[name] {
+		name += "_"
+	}
+	used[name] = true
+	return name
+}
+
+func exportedName(name string) string {
+	for i, r := range name {
+		return string(unicode.ToUpper(r)) + name[i+len(string(r)):]
+	}
+	return name
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// typeOf type checks src, a file of package p without imports, and returns
+// its type called name.
+func typeOf(t *testing.T, src, name string) types.Type {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", "package p\n\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := (&types.Config{}).Check("p", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg.Scope().Lookup(name).Type()
+}
+
+const mapperSource = `
+type Property struct{ Type string }
+
+type Location struct{ Lat, Lon float64 }
+
+func (Location) ElasticProperty() *Property { return &Property{Type: "geo_point"} }
+
+type Shape struct{ Points []Location }
+
+func (*Shape) ElasticProperty() *Property { return &Property{Type: "geo_shape"} }
+
+type Mapper interface{ ElasticProperty() *Property }
+
+type Place struct {
+	Name string
+	At   Location
+}
+
+type Area struct {
+	Name    string
+	Outline *Shape
+}
+
+type Places struct{ Places []*Place }
+
+type Plain struct {
+	Name  string
+	Other Mapper
+}
+`
+
+func TestConvertMapper(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Location", "type p.Location has an ElasticProperty method"},
+		{"Place", "field At: type p.Location has an ElasticProperty method"},
+		{"Area", "field Outline: type p.Shape has an ElasticProperty method"},
+		{"Places", "field Places: field At: type p.Location"},
+		{"Plain", ""},
+	}
+	for _, test := range tests {
+		_, err := newConverter().convert(typeOf(t, mapperSource, test.name))
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+func genUsage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "usage: elasticmapper gen [flags] [packages]\n\n")
+		fmt.Fprintf(os.Stderr, "Writes a mapping file next to the source of every struct marked with\n\n")
+		fmt.Fprintf(os.Stderr, "\t%s [target=<target>] [type=<type name>] [file=<file name>]\n\n", directive)
+		fmt.Fprintf(os.Stderr, "in its doc comment. Packages default to \".\".\n\nflags:\n")
+		flags.PrintDefaults()
+	}
+}
+
+func runGen(args []string) error {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	target := flags.String("target", "", "default target for the mappings, e.g. es8 or opensearch-2 (default: legacy layout)")
+	check := flags.Bool("check", false, "don't write anything, fail if any mapping file is missing or stale")
+	strict := flags.Bool("strict", false, "fail if any field can't be mapped")
+	precise := flags.Bool("precise-numbers", false, "map numbers to the smallest type that holds them instead of long and double")
+	flags.Usage = genUsage(flags)
+	flags.Parse(args)
+
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	defaultTarget, err := parseTarget(*target)
+	if err != nil {
+		return err
+	}
+
+	packages, err := loadPackages(patterns)
+	if err != nil {
+		return err
+	}
+	c := newConverter()
+	var stale []string
+	for _, pkg := range packages {
+		tagged, err := findTaggedTypes(pkg)
+		if err != nil {
+			return err
+		}
+		for _, t := range tagged {
+			path, mapping, err := generate(c, t, defaultTarget, elasticmapper.Options{Strict: *strict, PreciseNumbers: *precise})
+			if err != nil {
+				return fmt.Errorf("%s.%s: %v", t.Pkg.Path, t.Name, err)
+			}
+			if *check {
+				existing, err := ioutil.ReadFile(path)
+				if err != nil || !bytes.Equal(existing, mapping) {
+					stale = append(stale, path)
+				}
+				continue
+			}
+			if err := ioutil.WriteFile(path, mapping, 0644); err != nil {
+				return err
+			}
+			fmt.Println(path)
+		}
+	}
+	if len(stale) > 0 {
+		for _, path := range stale {
+			fmt.Fprintf(os.Stderr, "stale: %s\n", path)
+		}
+		return errors.New("mapping files are out of date; run elasticmapper gen")
+	}
+	return nil
+}
+
+// generate returns the path and content of the mapping file for t, using opts
+// along with the target from defaultTarget or the directive.
+func generate(c *converter, t *taggedType, defaultTarget elasticmapper.Target, opts elasticmapper.Options) (string, []byte, error) {
+	target := defaultTarget
+	typeName := t.Name
+	file := fileName(t.Name)
+	for key, value := range t.Args {
+		var err error
+		switch key {
+		case "target":
+			target, err = parseTarget(value)
+		case "type":
+			typeName = value
+		case "file":
+			file = value
+		default:
+			err = fmt.Errorf("unknown directive argument %q", key)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	rt, err := c.convert(t.Type)
+	if err != nil {
+		return "", nil, err
+	}
+	opts.Target = target
+	mapping, err := elasticmapper.GetElasticMappingWithOptions(reflect.Zero(rt).Interface(), typeName, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return filepath.Join(t.Pkg.Dir, file), []byte(mapping + "\n"), nil
+}
+
+// parseTarget is elasticmapper.ParseTarget, except that an empty string is the
+// legacy target.
+func parseTarget(s string) (elasticmapper.Target, error) {
+	if s == "" {
+		return elasticmapper.Target{}, nil
+	}
+	return elasticmapper.ParseTarget(s)
+}
+
+// fileName returns the default name of the mapping file for a type, like
+// "my_type.mapping.json" for MyType.
+func fileName(typeName string) string {
+	var b strings.Builder
+	runes := []rune(typeName)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word at "aB" and at the "B" of "ABc"
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + ".mapping.json"
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// listedPackage is the part of the output of go list we care about.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string
+	Export     string
+	DepOnly    bool
+	ImportMap  map[string]string
+	Error      *struct{ Err string }
+}
+
+// loadedPackage is a parsed and type checked package.
+type loadedPackage struct {
+	Path  string
+	Dir   string
+	Fset  *token.FileSet
+	Files []*ast.File
+	Types *types.Package
+}
+
+// loadPackages parses and type checks the packages matching patterns. It uses
+// go list to find them and to compile export data for their dependencies, but
+// never runs any of their code.
+func loadPackages(patterns []string) ([]*loadedPackage, error) {
+	args := append([]string{"list", "-e", "-json", "-export", "-deps", "--"}, patterns...)
+	cmd := exec.Command("go", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v: %s", err, stderr.String())
+	}
+
+	exports := make(map[string]string)
+	var targets []*listedPackage
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		p := &listedPackage{}
+		if err := decoder.Decode(p); err != nil {
+			return nil, fmt.Errorf("reading go list output: %v", err)
+		}
+		if p.Error != nil {
+			return nil, fmt.Errorf("package %s: %s", p.ImportPath, p.Error.Err)
+		}
+		exports[p.ImportPath] = p.Export
+		if !p.DepOnly {
+			targets = append(targets, p)
+		}
+	}
+
+	fset := token.NewFileSet()
+	gcImporter := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		export, ok := exports[path]
+		if !ok || export == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(export)
+	})
+
+	var loaded []*loadedPackage
+	for _, p := range targets {
+		pkg := &loadedPackage{Path: p.ImportPath, Dir: p.Dir, Fset: fset}
+		for _, name := range p.GoFiles {
+			file, err := parser.ParseFile(fset, filepath.Join(p.Dir, name), nil, parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			pkg.Files = append(pkg.Files, file)
+		}
+		config := &types.Config{Importer: mappedImporter{gcImporter, p.ImportMap}}
+		if pkg.Types, err = config.Check(p.ImportPath, fset, pkg.Files, nil); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, pkg)
+	}
+	return loaded, nil
+}
+
+// mappedImporter resolves import paths through the ImportMap of a package,
+// which is how go list tells us about vendored packages.
+type mappedImporter struct {
+	importer  types.Importer
+	importMap map[string]string
+}
+
+func (m mappedImporter) Import(path string) (*types.Package, error) {
+	if mapped, ok := m.importMap[path]; ok {
+		path = mapped
+	}
+	return m.importer.Import(path)
+}
+
+// directive marks a struct type to generate a mapping for:
+//
+//	//elasticmapper:generate target=es8 file=things.json
+const directive = "//elasticmapper:generate"
+
+// taggedType is a struct type marked with the directive.
+type taggedType struct {
+	Pkg  *loadedPackage
+	Name string
+	Type *types.Named
+	// Args are the key=value arguments of the directive.
+	Args map[string]string
+}
+
+// findTaggedTypes returns the types in pkg marked with the directive, either
+// in the doc comment of the type or of the type declaration around it.
+func findTaggedTypes(pkg *loadedPackage) ([]*taggedType, error) {
+	var tagged []*taggedType
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				args, found, err := parseDirective(typeSpec.Doc)
+				if !found && err == nil && len(genDecl.Specs) == 1 {
+					args, found, err = parseDirective(genDecl.Doc)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", pkg.Fset.Position(typeSpec.Pos()), err)
+				}
+				if !found {
+					continue
+				}
+				named, ok := pkg.Types.Scope().Lookup(typeSpec.Name.Name).Type().(*types.Named)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s is not a named type", pkg.Fset.Position(typeSpec.Pos()), typeSpec.Name.Name)
+				}
+				if _, ok := named.Underlying().(*types.Struct); !ok {
+					return nil, fmt.Errorf("%s: %s is not a struct", pkg.Fset.Position(typeSpec.Pos()), typeSpec.Name.Name)
+				}
+				tagged = append(tagged, &taggedType{Pkg: pkg, Name: typeSpec.Name.Name, Type: named, Args: args})
+			}
+		}
+	}
+	return tagged, nil
+}
+
+// parseDirective looks for the directive in doc and returns its arguments.
+func parseDirective(doc *ast.CommentGroup) (args map[string]string, found bool, err error) {
+	if doc == nil {
+		return nil, false, nil
+	}
+	for _, comment := range doc.List {
+		if comment.Text != directive && !strings.HasPrefix(comment.Text, directive+" ") {
+			continue
+		}
+		args = make(map[string]string)
+		for _, arg := range strings.Fields(strings.TrimPrefix(comment.Text, directive)) {
+			eq := strings.Index(arg, "=")
+			if eq <= 0 {
+				return nil, true, fmt.Errorf("bad directive argument %q, expected key=value", arg)
+			}
+			args[arg[:eq]] = arg[eq+1:]
+		}
+		return args, true, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,47 @@
+// Command elasticmapper generates ElasticSearch mappings for Go types without
+// having to write a program that calls the library for each of them.
+//
+// Usage:
+//
+//	elasticmapper gen [-target es8] [-check] ./pkg/...
+//	elasticmapper structs [-package pkg] [-type Thing] mapping.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// commands are the subcommands, keyed by name.
+var commands = map[string]func(args []string) error{
+	"gen":     runGen,
+	"structs": runStructs,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: elasticmapper <command> [flags] [args]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  gen      write mapping files for tagged structs in Go packages\n")
+	fmt.Fprintf(os.Stderr, "  structs  write Go structs for an existing mapping\n")
+	fmt.Fprintf(os.Stderr, "\nRun 'elasticmapper <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "elasticmapper: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "elasticmapper %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+func structsUsage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "usage: elasticmapper structs [flags] [mapping file]\n\n")
+		fmt.Fprintf(os.Stderr, "Writes Go structs whose mapping is the one in the file, or on stdin if\n")
+		fmt.Fprintf(os.Stderr, "there's no file. The file may hold a get mapping response, a create index\n")
+		fmt.Fprintf(os.Stderr, "body or just the mapping, with or without a type name.\n\nflags:\n")
+		flags.PrintDefaults()
+	}
+}
+
+func runStructs(args []string) error {
+	flags := flag.NewFlagSet("structs", flag.ExitOnError)
+	packageName := flags.String("package", "main", "package of the generated file")
+	typeName := flags.String("type", "Document", "name of the struct for the whole document")
+	output := flags.String("o", "", "file to write to (default: stdout)")
+	flags.Usage = structsUsage(flags)
+	flags.Parse(args)
+
+	var data []byte
+	var err error
+	switch flags.NArg() {
+	case 0:
+		data, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		data, err = ioutil.ReadFile(flags.Arg(0))
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+	mapping, err := elasticmapper.ParseMapping(data)
+	if err != nil {
+		return err
+	}
+	src, err := elasticmapper.GenerateStructs(mapping, *packageName, *typeName)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(*output, src, 0644)
+}
@@ -0,0 +1,429 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Compatibility says how a change to a mapping can be rolled out.
+type Compatibility int
+
+// Compatibilities from best to worst.
+const (
+	// Safe changes can be applied to the live index with a put mapping
+	// request.
+	Safe Compatibility = iota
+	// NeedsReindex changes are rejected on the live index, but reindexing
+	// the documents into a new index with the new mapping works.
+	NeedsReindex
+	// Conflicting changes don't fit the documents already indexed, so even a
+	// reindex is likely to fail or lose data.
+	Conflicting
+)
+
+func (c Compatibility) String() string {
+	switch c {
+	case Safe:
+		return "safe"
+	case NeedsReindex:
+		return "needs-reindex"
+	case Conflicting:
+		return "conflicting"
+	}
+	return fmt.Sprintf("Compatibility(%d)", int(c))
+}
+
+// ChangeKind is what happened to a field between two mappings.
+type ChangeKind string
+
+// The kinds of changes a diff reports.
+const (
+	FieldAdded       ChangeKind = "added"
+	FieldRemoved     ChangeKind = "removed"
+	TypeChanged      ChangeKind = "type-changed"
+	ParameterChanged ChangeKind = "parameter-changed"
+	TemplateChanged  ChangeKind = "template-changed"
+)
+
+// Change is a single difference between a live mapping and a generated one.
+type Change struct {
+	// Path is the dotted path of the field. Multi-fields are addressed like
+	// properties, as in "title.raw", and dynamic templates as
+	// "dynamic_templates.<name>".
+	Path string
+	Kind ChangeKind
+	// Parameter is the mapping parameter that changed, e.g. "analyzer", for
+	// ParameterChanged.
+	Parameter string
+	// Old and New are the type or parameter value before and after.
+	Old, New      interface{}
+	Compatibility Compatibility
+}
+
+func (c *Change) String() string {
+	switch c.Kind {
+	case FieldAdded, FieldRemoved, TemplateChanged:
+		return fmt.Sprintf("%s %s (%s)", c.Path, c.Kind, c.Compatibility)
+	case ParameterChanged:
+		if c.Path == "" {
+			// A parameter of the mapping itself
+			return fmt.Sprintf("%s changed from %s to %s (%s)", c.Parameter, formatValue(c.Old), formatValue(c.New), c.Compatibility)
+		}
+		return fmt.Sprintf("%s: %s changed from %s to %s (%s)", c.Path, c.Parameter, formatValue(c.Old), formatValue(c.New), c.Compatibility)
+	}
+	return fmt.Sprintf("%s: type changed from %v to %v (%s)", c.Path, c.Old, c.New, c.Compatibility)
+}
+
+// dynamicValue returns d as a plain JSON value, like the parameters of
+// properties in a Change.
+func dynamicValue(d DynamicMode) interface{} {
+	if d == "" {
+		return nil
+	}
+	var v interface{}
+	data, _ := d.MarshalJSON()
+	json.Unmarshal(data, &v)
+	return v
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "unset"
+	}
+	return fmt.Sprint(v)
+}
+
+// MappingDiff lists the changes needed to go from a live mapping to a
+// generated one.
+type MappingDiff struct {
+	Changes []*Change
+}
+
+// Compatibility returns the worst compatibility of all the changes, which is
+// how the new mapping as a whole can be rolled out.
+func (d *MappingDiff) Compatibility() Compatibility {
+	worst := Safe
+	for _, c := range d.Changes {
+		if c.Compatibility > worst {
+			worst = c.Compatibility
+		}
+	}
+	return worst
+}
+
+// CheckCompatibility compares generated against the mapping of a live index,
+// as returned by the get mapping API, and reports what it would take to
+// switch over.
+func CheckCompatibility(generated *Mapping, live []byte) (*MappingDiff, error) {
+	liveMapping, err := ParseMapping(live)
+	if err != nil {
+		return nil, err
+	}
+	return DiffMappings(liveMapping, generated)
+}
+
+// ParseMapping reads a mapping in any of the shapes ElasticSearch and
+// OpenSearch hand them out: a get mapping response for a single index, a
+// create index body, or just the mapping, each with or without a type name.
+func ParseMapping(data []byte) (*Mapping, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for {
+		if mappings, ok := raw["mappings"]; ok {
+			// {"mappings": ...}, possibly from a create index body or a
+			// get mapping response for an index
+			raw = nil
+			if err := json.Unmarshal(mappings, &raw); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		_, hasProperties := raw["properties"]
+		_, hasTemplates := raw["dynamic_templates"]
+		if hasProperties || hasTemplates || len(raw) == 0 {
+			break
+		}
+		if len(raw) != 1 {
+			return nil, errors.New("elasticmapper: can't find the mapping; expected a single index or type")
+		}
+		// {"<index or type name>": ...}
+		for _, inner := range raw {
+			raw = nil
+			if err := json.Unmarshal(inner, &raw); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	mapping := &Mapping{}
+	return mapping, json.Unmarshal(data, mapping)
+}
+
+// DiffMappings lists the changes needed to go from live to generated.
+func DiffMappings(live, generated *Mapping) (*MappingDiff, error) {
+	d := &MappingDiff{}
+	if live.Dynamic != generated.Dynamic {
+		d.add(&Change{Path: "", Kind: ParameterChanged, Parameter: "dynamic", Old: dynamicValue(live.Dynamic), New: dynamicValue(generated.Dynamic), Compatibility: Safe})
+	}
+	if err := d.rootParams(live.Extra, generated.Extra); err != nil {
+		return nil, err
+	}
+	if err := d.properties("", live.Properties, generated.Properties); err != nil {
+		return nil, err
+	}
+	if err := d.templates(live.DynamicTemplates, generated.DynamicTemplates); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// rootParams compares the parameters of the mappings themselves that only
+// exist in Extra, like _source or date_detection.
+func (d *MappingDiff) rootParams(live, generated map[string]interface{}) error {
+	var liveParams, generatedParams map[string]interface{}
+	if err := normalize(live, &liveParams); err != nil {
+		return err
+	}
+	if err := normalize(generated, &generatedParams); err != nil {
+		return err
+	}
+	d.params("", liveParams, generatedParams, updatableRootParams)
+	return nil
+}
+
+// params adds a change for each parameter that differs between live and
+// generated, which are plain JSON values.
+func (d *MappingDiff) params(path string, live, generated map[string]interface{}, updatable map[string]bool) {
+	names := make([]string, 0, len(live)+len(generated))
+	for name := range live {
+		names = append(names, name)
+	}
+	for name := range generated {
+		if _, ok := live[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l, g := live[name], generated[name]
+		if reflect.DeepEqual(l, g) {
+			continue
+		}
+		compatibility := NeedsReindex
+		if updatable[name] || (disableOnlyParams[name] && g == false) {
+			compatibility = Safe
+		}
+		d.add(&Change{Path: path, Kind: ParameterChanged, Parameter: name, Old: l, New: g, Compatibility: compatibility})
+	}
+}
+
+func (d *MappingDiff) add(c *Change) {
+	d.Changes = append(d.Changes, c)
+}
+
+func (d *MappingDiff) properties(path string, live, generated map[string]*Property) error {
+	names := make(map[string]bool)
+	for name := range live {
+		names[name] = true
+	}
+	for name := range generated {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		fieldPath := joinPath(path, name)
+		l, g := live[name], generated[name]
+		switch {
+		case l == nil:
+			d.add(&Change{Path: fieldPath, Kind: FieldAdded, New: propertyType(g), Compatibility: Safe})
+		case g == nil:
+			// A put mapping request can't remove a field, but it leaves
+			// the old one in place without complaining either.
+			d.add(&Change{Path: fieldPath, Kind: FieldRemoved, Old: propertyType(l), Compatibility: Safe})
+		default:
+			if err := d.property(fieldPath, l, g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *MappingDiff) property(path string, live, generated *Property) error {
+	liveType, generatedType := propertyType(live), propertyType(generated)
+	if liveType != generatedType {
+		d.add(&Change{Path: path, Kind: TypeChanged, Old: liveType, New: generatedType, Compatibility: typeChangeCompatibility(liveType, generatedType)})
+		return nil
+	}
+
+	liveParams, err := propertyParams(live)
+	if err != nil {
+		return err
+	}
+	generatedParams, err := propertyParams(generated)
+	if err != nil {
+		return err
+	}
+	d.params(path, liveParams, generatedParams, updatableParams)
+
+	if err := d.properties(path, live.Properties, generated.Properties); err != nil {
+		return err
+	}
+	return d.properties(path, live.Fields, generated.Fields)
+}
+
+func (d *MappingDiff) templates(live, generated []*DynamicTemplate) error {
+	byName := func(templates []*DynamicTemplate) (map[string]interface{}, error) {
+		named := make(map[string]interface{}, len(templates))
+		for _, t := range templates {
+			var normalized interface{}
+			if err := normalize(t, &normalized); err != nil {
+				return nil, err
+			}
+			named[t.Name] = normalized
+		}
+		return named, nil
+	}
+	liveTemplates, err := byName(live)
+	if err != nil {
+		return err
+	}
+	generatedTemplates, err := byName(generated)
+	if err != nil {
+		return err
+	}
+	// Dynamic templates are replaced as a whole by a put mapping request,
+	// so any change to them is safe.
+	for _, t := range generated {
+		if l, ok := liveTemplates[t.Name]; !ok {
+			d.add(&Change{Path: "dynamic_templates." + t.Name, Kind: FieldAdded, Compatibility: Safe})
+		} else if !reflect.DeepEqual(l, generatedTemplates[t.Name]) {
+			d.add(&Change{Path: "dynamic_templates." + t.Name, Kind: TemplateChanged, Compatibility: Safe})
+		}
+	}
+	for _, t := range live {
+		if _, ok := generatedTemplates[t.Name]; !ok {
+			d.add(&Change{Path: "dynamic_templates." + t.Name, Kind: FieldRemoved, Compatibility: Safe})
+		}
+	}
+	return nil
+}
+
+// updatableParams are the mapping parameters that can be changed on a live
+// index with a put mapping request.
+var updatableParams = stringSet(
+	"search_analyzer", "search_quote_analyzer", "ignore_above", "copy_to",
+	"ignore_malformed", "dynamic", "meta", "boost", "fielddata",
+	"eager_global_ordinals",
+)
+
+// disableOnlyParams are the mapping parameters that can be turned off on a
+// live index, but not turned back on.
+var disableOnlyParams = stringSet("norms")
+
+// updatableRootParams are the parameters of a mapping itself that can be
+// changed on a live index with a put mapping request. Others, like _source
+// and _routing, are fixed when the index is created.
+var updatableRootParams = stringSet(
+	"_meta", "date_detection", "numeric_detection", "dynamic_date_formats", "runtime",
+)
+
+// propertyType returns the type of p, treating properties that only have
+// properties as objects, which is how ElasticSearch hands them out.
+func propertyType(p *Property) string {
+	if p.Type == "" {
+		return "object"
+	}
+	return p.Type
+}
+
+// propertyParams returns the mapping parameters of p besides its type and
+// its properties and multi-fields, as plain JSON values.
+func propertyParams(p *Property) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	if err := normalize(p, &params); err != nil {
+		return nil, err
+	}
+	delete(params, "type")
+	delete(params, "properties")
+	delete(params, "fields")
+	return params, nil
+}
+
+// normalize turns v into plain JSON values, so that e.g. an int64 from a tag
+// and a float64 from a live mapping compare as equal.
+func normalize(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// typeFamilies groups the field types whose values look alike in a document,
+// so that changing between them works out with a reindex.
+var typeFamilies = map[string]string{
+	"text": "string", "keyword": "string", "wildcard": "string", "match_only_text": "string",
+	"search_as_you_type": "string", "constant_keyword": "string", "completion": "string",
+	"byte": "integer", "short": "integer", "integer": "integer", "long": "integer", "unsigned_long": "integer",
+	"half_float": "float", "float": "float", "double": "float", "scaled_float": "float",
+	"date": "date", "date_nanos": "date",
+	"object": "object", "nested": "object",
+}
+
+// numericWidth orders numeric types so we can tell widening from narrowing.
+// unsigned_long is wider than long, but see typeChangeCompatibility.
+var numericWidth = map[string]int{
+	"byte": 1, "short": 2, "integer": 3, "long": 4, "unsigned_long": 5,
+	"half_float": 6, "scaled_float": 6, "float": 7, "double": 8,
+}
+
+// typeChangeCompatibility says whether documents indexed with a field of type
+// from can be reindexed into a field of type to.
+func typeChangeCompatibility(from, to string) Compatibility {
+	fromFamily, toFamily := typeFamilies[from], typeFamilies[to]
+	switch {
+	case fromFamily == "object" && to == "flattened":
+		return NeedsReindex
+	case (from == "long" && to == "unsigned_long") || (from == "unsigned_long" && to == "long"):
+		// Neither holds all the values of the other, so it's only a reindex
+		// if the documents happen to fit
+		return NeedsReindex
+	case fromFamily == "object" || toFamily == "object":
+		// object and nested can swap, but values and objects can't
+		if fromFamily == toFamily {
+			return NeedsReindex
+		}
+		return Conflicting
+	case fromFamily == toFamily && fromFamily != "":
+		if numericWidth[to] < numericWidth[from] {
+			return Conflicting
+		}
+		return NeedsReindex
+	case toFamily == "string":
+		// Any value can be indexed as a string
+		return NeedsReindex
+	case (fromFamily == "integer" || fromFamily == "float") && (toFamily == "integer" || toFamily == "float"):
+		if numericWidth[to] < numericWidth[from] {
+			return Conflicting
+		}
+		return NeedsReindex
+	case fromFamily == "integer" && toFamily == "date":
+		// Numbers are taken as epoch millis
+		return NeedsReindex
+	}
+	return Conflicting
+}
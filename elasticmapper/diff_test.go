@@ -0,0 +1,55 @@
+package elasticmapper_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+func TestDiffCompatibility(t *testing.T) {
+	tests := []struct {
+		name            string
+		live, generated string
+		want            []string
+	}{
+		{"same", `{"type":"text"}`, `{"type":"text"}`, nil},
+		{"fielddata on", `{"type":"text"}`, `{"type":"text","fielddata":true}`,
+			[]string{"title: fielddata changed from unset to true (safe)"}},
+		{"fielddata off", `{"type":"text","fielddata":true}`, `{"type":"text","fielddata":false}`,
+			[]string{"title: fielddata changed from true to false (safe)"}},
+		{"eager_global_ordinals", `{"type":"keyword"}`, `{"type":"keyword","eager_global_ordinals":true}`,
+			[]string{"title: eager_global_ordinals changed from unset to true (safe)"}},
+		{"norms off", `{"type":"text"}`, `{"type":"text","norms":false}`,
+			[]string{"title: norms changed from unset to false (safe)"}},
+		{"norms back on", `{"type":"text","norms":false}`, `{"type":"text","norms":true}`,
+			[]string{"title: norms changed from false to true (needs-reindex)"}},
+		{"norms back to the default", `{"type":"text","norms":false}`, `{"type":"text"}`,
+			[]string{"title: norms changed from false to unset (needs-reindex)"}},
+		{"analyzer", `{"type":"text"}`, `{"type":"text","analyzer":"english"}`,
+			[]string{"title: analyzer changed from unset to english (needs-reindex)"}},
+		{"long to unsigned_long", `{"type":"long"}`, `{"type":"unsigned_long"}`,
+			[]string{"title: type changed from long to unsigned_long (needs-reindex)"}},
+	}
+	for _, test := range tests {
+		live, err := elasticmapper.ParseMapping([]byte(`{"properties":{"title":` + test.live + `}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated, err := elasticmapper.ParseMapping([]byte(`{"properties":{"title":` + test.generated + `}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff, err := elasticmapper.DiffMappings(live, generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, change := range diff.Changes {
+			got = append(got, change.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// DynamicMode is what ElasticSearch does with fields of a document that
+// aren't in the mapping of the object holding them.
+type DynamicMode string
+
+const (
+	// DynamicTrue adds new fields to the mapping. It's the default.
+	DynamicTrue DynamicMode = "true"
+	// DynamicFalse keeps new fields in _source without indexing them.
+	DynamicFalse DynamicMode = "false"
+	// DynamicStrict rejects documents with new fields.
+	DynamicStrict DynamicMode = "strict"
+	// DynamicRuntime makes new fields runtime fields, which are searchable
+	// but not indexed. It needs ElasticSearch 7.11.
+	DynamicRuntime DynamicMode = "runtime"
+)
+
+func (d DynamicMode) valid() bool {
+	switch d {
+	case DynamicTrue, DynamicFalse, DynamicStrict, DynamicRuntime:
+		return true
+	}
+	return false
+}
+
+// MarshalJSON writes true and false as booleans, the way ElasticSearch hands
+// them out.
+func (d DynamicMode) MarshalJSON() ([]byte, error) {
+	switch d {
+	case DynamicTrue:
+		return []byte("true"), nil
+	case DynamicFalse:
+		return []byte("false"), nil
+	}
+	return json.Marshal(string(d))
+}
+
+// UnmarshalJSON reads a mode written as either a boolean or a string.
+func (d *DynamicMode) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*d = DynamicFalse
+		if b {
+			*d = DynamicTrue
+		}
+		return nil
+	}
+	return json.Unmarshal(data, (*string)(d))
+}
+
+// Object is a marker for options that apply to a struct wherever it's mapped,
+// rather than to one field holding it. Add it as a blank field, which
+// encoding/json leaves alone, and tag it:
+//
+//	type Thing struct {
+//		_ elasticmapper.Object `elasticmapper:"dynamic=strict"`
+//	}
+//
+// The tag of a field holding the struct takes precedence.
+type Object struct{}
+
+var objectMarkerType = reflect.TypeOf(Object{})
+
+// objectOptions returns the options from the Object marker of struct type t.
+func objectOptions(t reflect.Type) (tagOptions, error) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type != objectMarkerType {
+			continue
+		}
+		opts, err := parseTag(sf.Tag.Get(structTag))
+		if err != nil {
+			return opts, err
+		}
+		if opts.Type != "" || opts.Skip || opts.leafOptionsSet() || opts.IncludeInParent != nil || opts.IncludeInRoot != nil || opts.Enabled != nil {
+			return opts, fmt.Errorf("only dynamic can be set on %s", sf.Type)
+		}
+		return opts, nil
+	}
+	return tagOptions{}, nil
+}
+
+// checkDynamic fails if the target doesn't support mode.
+func (m *mapper) checkDynamic(t reflect.Type, path string, mode DynamicMode) error {
+	target := m.opts.Target
+	if mode == DynamicRuntime && target != (Target{}) && (target.Engine != Elasticsearch || !target.atLeast(version{7, 11})) {
+		return &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("dynamic=runtime isn't supported by %s", target)}
+	}
+	return nil
+}
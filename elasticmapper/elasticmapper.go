@@ -1,172 +1,615 @@
 package elasticmapper
 
 import (
-	"log"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
-	"strings"
-	"time"
-
-	"github.com/Jeffail/gabs"
+	"sort"
 )
 
 const (
 	structTag = "elasticmapper"
 )
 
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// typeNames are the ElasticSearch field types that can be named in a tag to
+// override the type of a field, e.g. `elasticmapper:"text"`.
+var typeNames = []string{
+	"text", "keyword", "long", "integer", "short", "byte", "unsigned_long",
+	"double", "float", "half_float", "scaled_float", "boolean", "date",
+	"date_nanos", "ip", "binary", "object", "flattened", "flat_object",
+	"wildcard", "match_only_text", "integer_range", "long_range",
+	"float_range", "double_range", "date_range", "ip_range", "geo_point",
+	"geo_shape", "completion", "search_as_you_type", "dense_vector",
+	"knn_vector", "nested", "join",
+}
+
+// objectValueTypes are the types whose values may be JSON objects, such as a
+// range like {"gte": 1, "lt": 10} or a GeoJSON shape, so a struct or map field
+// can be mapped as one of them instead of as an object.
+var objectValueTypes = map[string]bool{
+	"integer_range": true, "long_range": true, "float_range": true,
+	"double_range": true, "date_range": true, "ip_range": true,
+	"geo_point": true, "geo_shape": true, "completion": true,
+	"flattened": true, "flat_object": true,
+}
+
+// vectorTypes are the types whose values may be arrays of numbers, like a
+// geo_point given as [lon, lat] or an embedding.
+var vectorTypes = map[string]bool{
+	"geo_point": true, "dense_vector": true, "knn_vector": true,
+}
+
+// Options controls how a mapping is generated.
+type Options struct {
+	// UseGoNames names properties after the Go struct fields instead of the
+	// names encoding/json would use, ignoring any renaming done via json tags.
+	UseGoNames bool
+
+	// InterfaceTypes maps an interface type to the concrete type whose
+	// mapping is used for fields of that interface type. A field can also
+	// declare its type in its tag instead, e.g. `elasticmapper:"keyword"`.
+	InterfaceTypes map[reflect.Type]reflect.Type
+
+	// Registry holds the mappings of types that can't be worked out from
+	// their structure. It defaults to DefaultRegistry.
+	Registry *Registry
+
+	// Strict makes mapping fail with a *MappingError if any field can't be
+	// mapped. Otherwise such fields are left out of the mapping and listed in
+	// Result.Warnings.
+	Strict bool
+
+	// Target is the engine and version to generate the mapping for. Field
+	// types the target doesn't support make mapping fail.
+	Target Target
+
+	// MaxDepth is how deep objects may be nested before mapping fails,
+	// counting the root as 1. It defaults to DefaultMaxDepth, which is
+	// ElasticSearch's own limit.
+	MaxDepth int
+
+	// OnCycle is what to do when a struct contains itself, like a tree node
+	// holding its children.
+	OnCycle CycleMode
+
+	// Dynamic is the dynamic mode of the root of the mapping. It takes
+	// precedence over an Object marker in the struct.
+	Dynamic DynamicMode
+
+	// PreciseNumbers maps each numeric kind to the smallest ElasticSearch
+	// type that holds all its values, e.g. int16 to short and float32 to
+	// float, instead of mapping every integer as long and every float as
+	// double. uint64 becomes unsigned_long where the target supports it.
+	PreciseNumbers bool
+}
+
+// DefaultMaxDepth is the default for Options.MaxDepth. It matches the default
+// of the index.mapping.depth.limit setting.
+const DefaultMaxDepth = 20
+
+// CycleMode decides what happens to a struct that contains itself.
+type CycleMode int
+
+const (
+	// CycleDisable maps the struct where it shows up again as an object with
+	// "enabled": false, which keeps it in _source without indexing it.
+	CycleDisable CycleMode = iota
+	// CycleError makes mapping fail with a *FieldError.
+	CycleError
+)
+
+// Result is a generated mapping along with the fields that were left out of it
+// because they couldn't be mapped.
+type Result struct {
+	Mapping  *Mapping
+	Warnings []*FieldError
+}
+
 // GetElasticMapping returns an ElasticSearch mapping from a struct
 // Arguments: input, which could be any struct and typeName, which is the name
-// of the type you wish to set in ElasticSearch
+// of the type you wish to set in ElasticSearch. The mapping uses the legacy
+// layout with a type name; use GetElasticMappingWithOptions with a Target for
+// ElasticSearch 7 onwards or OpenSearch.
 func GetElasticMapping(input interface{}, typeName string) (mapping string, err error) {
-	mappingTree := gabs.New()
-	rootTree, err := mappingTree.Object("mappings", typeName, "properties")
+	return GetElasticMappingWithOptions(input, typeName, Options{})
+}
+
+// GetElasticMappingWithOptions is GetElasticMapping with control over how the
+// mapping is generated. Property names follow encoding/json by default, so the
+// mapping matches documents indexed via json.Marshal.
+func GetElasticMappingWithOptions(input interface{}, typeName string, opts Options) (mapping string, err error) {
+	data, err := Generate(input, opts, &ElasticsearchEmitter{Target: opts.Target, TypeName: typeName})
+	mapping = string(data)
+	return
+}
+
+// Map generates the mapping for input, which must be a struct or a pointer to
+// one. Unless Options.Strict is set, fields that can't be mapped are left out
+// and reported in Result.Warnings. A join field has to be at the root, and
+// the join field of a parent needs its children, so map parents and children
+// together with MapAll.
+func Map(input interface{}, opts Options) (*Result, error) {
+	result, err := mapStruct(input, opts)
 	if err != nil {
-		return
+		return nil, err
 	}
-	err = getElasticMappingImpl(input, rootTree, typeName, false)
-	mapping = mappingTree.StringIndent("", "  ")
-	return
+	if err := checkJoins(result.Mapping); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// mapStruct is Map without checking join fields, which MapAll does once the
+// types are merged.
+func mapStruct(input interface{}, opts Options) (*Result, error) {
+	inputType := indirect(reflect.TypeOf(input))
+	if inputType == nil || inputType.Kind() != reflect.Struct {
+		return nil, errors.New("elasticmapper: input must be a struct or a pointer to one")
+	}
+	if err := opts.Target.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Dynamic != "" && !opts.Dynamic.valid() {
+		return nil, fmt.Errorf("elasticmapper: bad dynamic mode %q", opts.Dynamic)
+	}
+	root, err := objectOptions(inputType)
+	if err != nil {
+		return nil, &FieldError{Path: "_", Type: inputType, Reason: err.Error()}
+	}
+	m := &mapper{opts: opts, mapping: &Mapping{Dynamic: root.Dynamic}}
+	if opts.Dynamic != "" {
+		m.mapping.Dynamic = opts.Dynamic
+	}
+	if err := m.checkDynamic(inputType, "", m.mapping.Dynamic); err != nil {
+		return nil, err
+	}
+	m.dynamic = m.mapping.Dynamic
+	// The root is an object too, for catching cycles and counting depth
+	m.objects = append(m.objects, inputType)
+	properties, err := m.mapProperties(inputType, "")
+	m.objects = m.objects[:len(m.objects)-1]
+	if err != nil {
+		return nil, err
+	}
+	m.mapping.Properties = properties
+	if opts.Strict && len(m.unmapped) > 0 {
+		return nil, &MappingError{Fields: m.unmapped}
+	}
+	return &Result{Mapping: m.mapping, Warnings: m.unmapped}, nil
+}
+
+// mapper carries the state of a single Map call.
+type mapper struct {
+	opts Options
+	// mapping is the mapping being built, which is where dynamic templates
+	// are added as we come across maps.
+	mapping *Mapping
+	// unmapped are the fields left out of the mapping so far.
+	unmapped []*FieldError
+	// objects are the struct types being mapped on the way from the root,
+	// which is the first, to the current field, to catch structs that
+	// contain themselves.
+	objects []reflect.Type
+	// dynamic is the dynamic mode in effect for the current field, which
+	// objects inherit from their parents.
+	dynamic DynamicMode
+}
+
+// skip records that the field at path was left out of the mapping.
+func (m *mapper) skip(t reflect.Type, path, reason string) (*Property, error) {
+	m.unmapped = append(m.unmapped, &FieldError{Path: path, Type: t, Reason: reason})
+	return nil, nil
 }
 
-func getElasticMappingImpl(input interface{}, jsonTree *gabs.Container, structName string, isText bool) error {
-	// Handle special case of us defining time.Time
-	if t, ok := (input).(time.Time); ok {
-		return getElasticMappingImpl(t.Unix(), jsonTree, structName, false)
+// mapProperties returns a property for every field of struct type t that can
+// be mapped. path is the dotted path of the struct within the document.
+func (m *mapper) mapProperties(t reflect.Type, path string) (map[string]*Property, error) {
+	properties := make(map[string]*Property)
+	for _, f := range typeFields(t, m.opts.UseGoNames) {
+		if f.sf.Type == objectMarkerType {
+			continue
+		}
+		fieldPath := joinPath(path, f.name)
+		opts, err := parseTag(f.sf.Tag.Get(structTag))
+		if err != nil {
+			return nil, &FieldError{Path: fieldPath, Type: f.sf.Type, Reason: err.Error()}
+		}
+		if opts.Skip {
+			continue
+		}
+		property, err := m.mapField(f.sf.Type, fieldPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		if property != nil {
+			properties[f.name] = property
+		}
 	}
-	inputType := reflect.TypeOf(input)
+	return properties, nil
+}
 
-	switch inputType.Kind() {
-	case reflect.Array, reflect.Slice:
+// mapField returns the property for a field of type t, or nil if t can't be
+// mapped.
+func (m *mapper) mapField(t reflect.Type, path string, opts tagOptions) (*Property, error) {
+	t = indirect(t)
+	if property, ok := m.customProperty(t); ok {
+		return m.mapCustom(t, path, property, opts)
+	}
+
+	switch t.Kind() {
 	case reflect.Struct:
-		for i := 0; i < inputType.NumField(); i++ {
-			fieldType := inputType.Field(i)
-			tagString := fieldType.Tag.Get(structTag)
-			tagLookup := getTagLookup(tagString)
-			if tagLookup["-"] {
-				log.Printf("Encountered field %s with '-'set. Ignoring field!.\n", fieldType.Name)
-				continue
-			}
-			fieldValue := reflect.Zero(fieldType.Type)
-			fieldKind := fieldValue.Type().Kind()
-			innerValue := fieldValue.Interface()
-			// Handle special case of us defining time.Time
-			if _, ok := (innerValue).(time.Time); ok {
-				_, err := jsonTree.Set("date", fieldType.Name, "type")
-				if err != nil {
-					return err
-				}
-				continue
-			}
-			if fieldKind == reflect.Struct { // cannot support interfaces
-				childTreeContainer, err := jsonTree.Object(fieldType.Name)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-				_, err = childTreeContainer.Set("object", "type")
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-				childTree, err := childTreeContainer.Object("properties")
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-				// do note that fieldType.Name is useless if innerValue is a struct
-				err = getElasticMappingImpl(innerValue, childTree, "asfdADAdASD", false)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-			} else if fieldKind == reflect.Array || fieldKind == reflect.Slice {
-				// TODO we don't yet process timestamps properly
-				innerType := fieldType.Type.Elem()
-				zeroVal := reflect.Zero(innerType).Interface()
-				if innerType.Kind() == reflect.Struct {
-					// Handle special case of us defining time.Time
-					if _, ok := (zeroVal).(time.Time); ok {
-						_, err := jsonTree.Set("date", fieldType.Name, "type")
-						if err != nil {
-							return err
-						}
-						goto endArray
-					}
-					childTreeContainer, err := jsonTree.Object(fieldType.Name)
-					if err != nil {
-						log.Println(err)
-						return err
-					}
-					// Store arrays of structs as nested objects in ElasticSearch
-					childTreeContainer.Set("nested", "type")
-					childTree, err := childTreeContainer.Object("properties")
-					if err != nil {
-						log.Println(err)
-						return err
-					}
-					err = getElasticMappingImpl(zeroVal, childTree, "QWWEW5afasfsddfsf", false)
-					if err != nil {
-						log.Println(err)
-						return err
-					}
-				} else {
-					// Pass along
-					isText = tagLookup["text"]
-					childTreeContainer, err := jsonTree.Object(fieldType.Name)
-					if err != nil {
-						log.Println(err)
-						return err
-					}
-					err = getElasticMappingImpl(zeroVal, childTreeContainer, fieldType.Name, isText)
-					if err != nil {
-						log.Println(err)
-						return err
-					}
-				}
-			endArray:
-			} else {
-				isText = tagLookup["text"]
-				childTreeContainer, err := jsonTree.Object(fieldType.Name)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-				err = getElasticMappingImpl(innerValue, childTreeContainer, fieldType.Name, isText)
-				if err != nil {
-					log.Println(err)
-					return err
-				}
-			}
+		if objectValueTypes[opts.Type] {
+			return m.mapLeaf(t, path, opts.Type, opts)
 		}
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		jsonTree.Set("long", "type")
-	case reflect.String:
-		if isText {
-			jsonTree.Set("text", "type")
-		} else {
-			jsonTree.Set("keyword", "type")
+		if opts.Type == "nested" {
+			return m.mapObject(t, path, "nested", opts)
 		}
+		return m.mapObject(t, path, "object", opts)
+	case reflect.Array, reflect.Slice:
+		return m.mapSlice(t, path, opts)
+	case reflect.Map:
+		return m.mapMap(t, path, opts)
+	case reflect.Interface:
+		return m.mapInterface(t, path, opts)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return m.mapLeaf(t, path, m.numberType(t.Kind()), opts)
+	case reflect.String:
+		return m.mapLeaf(t, path, "keyword", opts)
 	case reflect.Bool:
-		jsonTree.Set("boolean", "type")
-	case reflect.Float32, reflect.Float64:
-		jsonTree.Set("double", "type")
-	case reflect.Ptr:
-		log.Printf("This is a pointer. WTF? '%v'\n", input)
+		return m.mapLeaf(t, path, "boolean", opts)
 	default:
-		// Will have to handle edge cases properly later
-		log.Printf("Unsupported type. reflect.Type value is : %d\n", inputType.Kind())
+		// Channels, functions, complex numbers and unsafe pointers have no
+		// JSON representation, let alone an ElasticSearch one.
+		return m.skip(t, path, fmt.Sprintf("unsupported kind %s", t.Kind()))
+	}
+}
+
+// preciseNumberTypes are the types numeric kinds map to with
+// Options.PreciseNumbers. Unsigned kinds need the next larger signed type.
+var preciseNumberTypes = map[reflect.Kind]string{
+	reflect.Int8:    "byte",
+	reflect.Int16:   "short",
+	reflect.Int32:   "integer",
+	reflect.Int64:   "long",
+	reflect.Int:     "long",
+	reflect.Uint8:   "short",
+	reflect.Uint16:  "integer",
+	reflect.Uint32:  "long",
+	reflect.Uint64:  "unsigned_long",
+	reflect.Uint:    "unsigned_long",
+	reflect.Float32: "float",
+	reflect.Float64: "double",
+}
+
+// numberType returns the ElasticSearch type for a numeric kind.
+func (m *mapper) numberType(kind reflect.Kind) string {
+	if !m.opts.PreciseNumbers {
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			return "double"
+		}
+		return "long"
+	}
+	typeName := preciseNumberTypes[kind]
+	if typeName == "unsigned_long" && !m.opts.Target.SupportsType(typeName) {
+		// Values above the int64 range won't index, but it's the best we
+		// can do
+		return "long"
+	}
+	return typeName
+}
+
+// mapSlice maps a slice or array field. Unless it's a single value like a
+// vector or base64 bytes, the property is marked as Multiple.
+func (m *mapper) mapSlice(t reflect.Type, path string, opts tagOptions) (*Property, error) {
+	if isByteSlice(t) {
+		// encoding/json writes these as base64 strings
+		return m.mapLeaf(t, path, "binary", opts)
+	}
+	innerType := indirect(t.Elem())
+	if vectorTypes[opts.Type] && isFloat(innerType) {
+		return m.mapVector(t, path, opts)
+	}
+	var property *Property
+	var err error
+	if _, custom := m.customProperty(innerType); custom || innerType.Kind() != reflect.Struct || objectValueTypes[opts.Type] {
+		// ElasticSearch has no array type; any field may hold several
+		// values, so an array maps the same as its elements.
+		property, err = m.mapField(innerType, path, opts)
+	} else if opts.Type == "object" {
+		property, err = m.mapObject(innerType, path, "object", opts)
+	} else {
+		// Store arrays of structs as nested objects in ElasticSearch, so
+		// each keeps its fields together, unless the tag says otherwise
+		property, err = m.mapObject(innerType, path, "nested", opts)
+	}
+	if property != nil {
+		property.Multiple = true
+	}
+	return property, err
+}
+
+// mapVector maps an array or slice of floats tagged with one of vectorTypes.
+// The length of an array is the number of dimensions of a vector, and must be
+// 2 for a geo_point, which is given as [lon, lat] like in GeoJSON.
+func (m *mapper) mapVector(t reflect.Type, path string, opts tagOptions) (*Property, error) {
+	if t.Kind() == reflect.Array {
+		n := t.Len()
+		switch {
+		case opts.Type == "geo_point" && n != 2:
+			return nil, &FieldError{Path: path, Type: t, Reason: "geo_point arrays hold [lon, lat] and need a length of 2"}
+		case opts.Type != "geo_point" && opts.Dims != nil && *opts.Dims != n:
+			return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("dims=%d doesn't match the array length %d", *opts.Dims, n)}
+		case opts.Type != "geo_point":
+			opts.Dims = &n
+		}
+	}
+	return m.mapLeaf(t, path, opts.Type, opts)
+}
+
+// mapObject maps struct type t as an object of the given ElasticSearch type,
+// which is either object or nested.
+func (m *mapper) mapObject(t reflect.Type, path, typeName string, opts tagOptions) (*Property, error) {
+	if opts.Type != "" && opts.Type != typeName {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("can't be mapped as %s", opts.Type)}
+	}
+	if opts.leafOptionsSet() {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("options in tag only apply to fields with values, not %s", typeName)}
+	}
+	if err := m.checkType(t, path, typeName); err != nil {
+		return nil, err
+	}
+	marker, err := objectOptions(t)
+	if err != nil {
+		return nil, &FieldError{Path: path, Type: t, Reason: err.Error()}
+	}
+	if opts.Dynamic == "" {
+		opts.Dynamic = marker.Dynamic
+	}
+	if err := m.checkDynamic(t, path, opts.Dynamic); err != nil {
+		return nil, err
+	}
+	property := &Property{Type: typeName}
+	if err := opts.applyObject(property); err != nil {
+		return nil, &FieldError{Path: path, Type: t, Reason: err.Error()}
+	}
+	if property.Enabled != nil && !*property.Enabled {
+		// Nothing in here gets indexed, so there's nothing to map
+		return property, nil
+	}
+	for _, outer := range m.objects {
+		if outer != t {
+			continue
+		}
+		if m.opts.OnCycle == CycleError {
+			return nil, &FieldError{Path: path, Type: t, Reason: "struct contains itself"}
+		}
+		enabled := false
+		return &Property{Type: "object", Enabled: &enabled}, nil
+	}
+	maxDepth := m.opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if len(m.objects) >= maxDepth {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("objects nested deeper than %d levels", maxDepth)}
+	}
+
+	m.objects = append(m.objects, t)
+	outerDynamic := m.dynamic
+	if property.Dynamic != "" {
+		m.dynamic = property.Dynamic
+	}
+	properties, err := m.mapProperties(t, path)
+	m.dynamic = outerDynamic
+	m.objects = m.objects[:len(m.objects)-1]
+	if err != nil {
+		return nil, err
+	}
+	property.Properties = properties
+	return property, nil
+}
+
+// mapLeaf maps a field holding a value of the given ElasticSearch type, unless
+// the tag asks for another type, and applies the options from the tag.
+func (m *mapper) mapLeaf(t reflect.Type, path, typeName string, opts tagOptions) (*Property, error) {
+	return m.applyLeafOptions(t, path, &Property{Type: typeName}, opts)
+}
+
+// mapCustom maps a field using the property from customProperty. Options from
+// the tag only apply to properties of fields with values; objects have to be
+// taken as they are.
+func (m *mapper) mapCustom(t reflect.Type, path string, property *Property, opts tagOptions) (*Property, error) {
+	if property == nil {
+		return m.skip(t, path, "ElasticProperty returned no mapping")
+	}
+	if property.Type != "" && property.Type != "object" && property.Type != "nested" {
+		return m.applyLeafOptions(t, path, property, opts)
+	}
+	if opts.Type != "" && opts.Type != propertyType(property) {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("can't be mapped as %s", opts.Type)}
+	}
+	if opts.leafOptionsSet() {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("options in tag only apply to fields with values, not %s", propertyType(property))}
+	}
+	if err := m.checkType(t, path, propertyType(property)); err != nil {
+		return nil, err
+	}
+	if err := m.checkDynamic(t, path, opts.Dynamic); err != nil {
+		return nil, err
+	}
+	if err := opts.applyObject(property); err != nil {
+		return nil, &FieldError{Path: path, Type: t, Reason: err.Error()}
+	}
+	return property, nil
+}
+
+// applyLeafOptions applies the type and options from the tag to property.
+func (m *mapper) applyLeafOptions(t reflect.Type, path string, property *Property, opts tagOptions) (*Property, error) {
+	if opts.Type != "" {
+		property.Type = opts.Type
+	}
+	if err := m.checkType(t, path, property.Type); err != nil {
+		return nil, err
+	}
+	for _, fieldType := range opts.Fields {
+		if err := m.checkType(t, path, fieldType); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.apply(property); err != nil {
+		return nil, &FieldError{Path: path, Type: t, Reason: err.Error()}
+	}
+	target := m.opts.Target
+	if property.Similarity != "" && target != (Target{}) && !target.atLeast(version{8, 0}) {
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("similarity isn't supported by %s", target)}
+	}
+	return property, nil
+}
+
+// checkType fails if the target doesn't support fields of the given type.
+func (m *mapper) checkType(t reflect.Type, path, typeName string) error {
+	if !m.opts.Target.SupportsType(typeName) {
+		return &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("%s fields aren't supported by %s", typeName, m.opts.Target)}
 	}
 	return nil
 }
 
-// getTagLookup returns a lookup table for a custom struct tag's options.
-// For example, if you set mytag="hello,world", the options "hello" and "world"
-// are active according to convention.
-func getTagLookup(tagString string) map[string]bool {
-	lookup := make(map[string]bool)
-	tags := strings.Split(tagString, ",")
-	for _, tag := range tags {
-		trimmedTag := strings.Trim(tag, " \t")
-		lookup[trimmedTag] = true
+// mapMap maps a map field. The keys of a map become property names that we
+// can't know up front, so the map itself is an object and the mapping of its
+// values goes into dynamic templates matching any key. With the `flattened`
+// tag the whole map is indexed as a single flattened field instead.
+func (m *mapper) mapMap(t reflect.Type, path string, opts tagOptions) (*Property, error) {
+	keyType := t.Key()
+	switch keyType.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		if !keyType.Implements(textMarshalerType) {
+			return m.skip(t, path, fmt.Sprintf("unsupported map key type %s", keyType))
+		}
+	}
+	if opts.Type == "flattened" || opts.Type == "flat_object" {
+		return m.mapLeaf(t, path, opts.Type, opts)
+	}
+	if opts.Enabled != nil && !*opts.Enabled {
+		if opts.Type != "" && opts.Type != "object" {
+			return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("can't be mapped as %s", opts.Type)}
+		}
+		return &Property{Type: "object", Enabled: opts.Enabled}, nil
+	}
+	// The keys of a map are new fields, so they have to be let in
+	switch opts.Dynamic {
+	case DynamicStrict, DynamicRuntime:
+		return nil, &FieldError{Path: path, Type: t, Reason: fmt.Sprintf("maps can't be dynamic=%s", opts.Dynamic)}
+	case DynamicFalse:
+		// Kept in _source but not indexed, so templates wouldn't apply
+		return &Property{Type: "object", Dynamic: DynamicFalse}, nil
+	}
+	switch indirect(t.Elem()).Kind() {
+	case reflect.Struct, reflect.Array, reflect.Slice, reflect.Map:
+	default:
+		// A map of plain values can be a value itself, like a GeoJSON
+		// shape or the bounds of a range
+		if objectValueTypes[opts.Type] {
+			return m.mapLeaf(t, path, opts.Type, opts)
+		}
+	}
+	// Any other options in the tag are about the values
+	valueOpts := opts
+	if valueOpts.Type == "object" {
+		valueOpts.Type = ""
+	}
+	valueOpts.Dynamic = ""
+	dynamic := opts.Dynamic
+	if dynamic == "" && m.dynamic != "" && m.dynamic != DynamicTrue {
+		// Don't inherit a mode that keeps out the keys
+		dynamic = DynamicTrue
+	}
+	outerDynamic := m.dynamic
+	m.dynamic = DynamicTrue
+	pathMatch := joinPath(path, "*")
+	value, err := m.mapField(t.Elem(), pathMatch, valueOpts)
+	m.dynamic = outerDynamic
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		m.addDynamicTemplates(pathMatch, value)
+	}
+	return &Property{Type: "object", Dynamic: dynamic}, nil
+}
+
+// addDynamicTemplates adds dynamic templates that apply property to every
+// field matching pathMatch. Objects don't need a template since ElasticSearch
+// maps them dynamically anyway, but their properties are added one by one.
+func (m *mapper) addDynamicTemplates(pathMatch string, property *Property) {
+	if property.Type != "object" {
+		leaf := *property
+		leaf.Properties = nil
+		m.mapping.DynamicTemplates = append(m.mapping.DynamicTemplates, &DynamicTemplate{
+			Name:      pathMatch,
+			PathMatch: pathMatch,
+			Mapping:   &leaf,
+		})
+	}
+	for _, name := range sortedKeys(property.Properties) {
+		m.addDynamicTemplates(joinPath(pathMatch, name), property.Properties[name])
+	}
+}
+
+// mapInterface maps an interface field using either the concrete type
+// registered for it in Options.InterfaceTypes or the type named in its tag.
+func (m *mapper) mapInterface(t reflect.Type, path string, opts tagOptions) (*Property, error) {
+	if concrete, ok := m.opts.InterfaceTypes[t]; ok {
+		return m.mapField(concrete, path, opts)
+	}
+	if opts.Type != "" {
+		return m.mapLeaf(t, path, opts.Type, opts)
+	}
+	return m.skip(t, path, "no concrete type registered and no type in tag")
+}
+
+func isFloat(t reflect.Type) bool {
+	return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
+
+// isByteSlice reports whether encoding/json writes values of type t as base64
+// strings, which it does for byte slices unless the bytes marshal themselves.
+func isByteSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uint8 {
+		return false
+	}
+	elem := reflect.PtrTo(t.Elem())
+	return !elem.Implements(textMarshalerType) && !elem.Implements(jsonMarshalerType)
+}
+
+// indirect returns the type t points to, following any number of pointers.
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// joinPath appends name to the dotted path of a field's parent.
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys(properties map[string]*Property) []string {
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		keys = append(keys, k)
 	}
-	return lookup
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,137 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Emitter writes a mapping in the format of a search backend. Map does the
+// walk over the Go types and their tags for all of them, and an Emitter turns
+// the resulting Mapping into whatever the backend wants, which is how the
+// same struct can describe an ElasticSearch index, a Solr schema and so on.
+type Emitter interface {
+	Emit(mapping *Mapping) ([]byte, error)
+}
+
+// Generate maps input with opts and writes the result with emitter.
+func Generate(input interface{}, opts Options, emitter Emitter) ([]byte, error) {
+	result, err := Map(input, opts)
+	if err != nil {
+		return nil, err
+	}
+	return emitter.Emit(result.Mapping)
+}
+
+// ElasticsearchEmitter writes a mapping as the body of a put mapping request,
+// like GetElasticMappingWithOptions.
+type ElasticsearchEmitter struct {
+	Target Target
+	// TypeName is the type to put the mapping under for targets before
+	// ElasticSearch 7.
+	TypeName string
+}
+
+// Emit implements Emitter.
+func (e *ElasticsearchEmitter) Emit(mapping *Mapping) ([]byte, error) {
+	body := map[string]interface{}{
+		"mappings": e.Target.wrap(mapping, e.TypeName),
+	}
+	return json.MarshalIndent(body, "", "  ")
+}
+
+// OpenSearchEmitter writes a mapping for OpenSearch, translating the field
+// types it names differently from ElasticSearch: dense_vector becomes
+// knn_vector, which needs the index.knn setting for approximate search, and
+// flattened becomes flat_object. Map with the zero Target or an ElasticSearch
+// one, since the types are checked against Target here instead.
+type OpenSearchEmitter struct {
+	// Target defaults to OpenSearch2.
+	Target Target
+}
+
+// knnSpaceTypes are the OpenSearch space types for dense_vector similarities.
+var knnSpaceTypes = map[string]string{
+	"l2_norm":           "l2",
+	"cosine":            "cosinesimil",
+	"dot_product":       "innerproduct",
+	"max_inner_product": "innerproduct",
+}
+
+// Emit implements Emitter.
+func (e *OpenSearchEmitter) Emit(mapping *Mapping) ([]byte, error) {
+	target := e.Target
+	if target == (Target{}) {
+		target = OpenSearch2
+	}
+	if target.Engine != OpenSearch {
+		return nil, fmt.Errorf("elasticmapper: %s is not an OpenSearch target", target)
+	}
+	if mapping.Dynamic == DynamicRuntime {
+		return nil, fmt.Errorf("elasticmapper: dynamic=runtime isn't supported by %s", target)
+	}
+	translated := *mapping
+	translated.Properties = cloneProperties(mapping.Properties)
+	translated.DynamicTemplates = make([]*DynamicTemplate, len(mapping.DynamicTemplates))
+	for i, template := range mapping.DynamicTemplates {
+		t := *template
+		t.Mapping = template.Mapping.Clone()
+		translated.DynamicTemplates[i] = &t
+	}
+
+	translate := func(path string, p *Property) error {
+		switch p.Type {
+		case "dense_vector":
+			p.Type, p.Dimension, p.Dims = "knn_vector", p.Dims, nil
+			if p.Dimension == nil {
+				return &FieldError{Path: path, Reason: "knn_vector fields need dims"}
+			}
+			if p.Similarity != "" {
+				if p.Extra == nil {
+					p.Extra = make(map[string]interface{})
+				}
+				p.Extra["method"] = map[string]interface{}{
+					"name":       "hnsw",
+					"engine":     "lucene",
+					"space_type": knnSpaceTypes[p.Similarity],
+				}
+			}
+			p.Similarity, p.Index = "", nil
+		case "flattened":
+			p.Type = "flat_object"
+		}
+		if p.Dynamic == DynamicRuntime {
+			return &FieldError{Path: path, Reason: fmt.Sprintf("dynamic=runtime isn't supported by %s", target)}
+		}
+		if !target.SupportsType(propertyType(p)) {
+			return &FieldError{Path: path, Reason: fmt.Sprintf("%s fields aren't supported by %s", p.Type, target)}
+		}
+		return nil
+	}
+	if err := eachProperty("", translated.Properties, translate); err != nil {
+		return nil, err
+	}
+	for _, template := range translated.DynamicTemplates {
+		if err := translate(template.PathMatch, template.Mapping); err != nil {
+			return nil, err
+		}
+	}
+	return (&ElasticsearchEmitter{Target: target}).Emit(&translated)
+}
+
+// eachProperty calls fn for every property in properties, their multi-fields
+// and their properties in turn, parents first.
+func eachProperty(path string, properties map[string]*Property, fn func(path string, p *Property) error) error {
+	for _, name := range sortedKeys(properties) {
+		p, fieldPath := properties[name], joinPath(path, name)
+		if err := fn(fieldPath, p); err != nil {
+			return err
+		}
+		if err := eachProperty(fieldPath, p.Fields, fn); err != nil {
+			return err
+		}
+		if err := eachProperty(fieldPath, p.Properties, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package elasticmapper
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldError describes a field that couldn't be mapped, either because its Go
+// type has no ElasticSearch equivalent or because its tag is wrong.
+type FieldError struct {
+	// Path is the dotted path of the field within the document, using the
+	// property names of the mapping. Values of maps show up as "*".
+	Path string
+	// Type is the Go type of the field. It's nil for problems found in a
+	// mapping after it was generated, like a reference to an unknown analyzer.
+	Type reflect.Type
+	// Reason says what's wrong with the field.
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return "elasticmapper: " + e.describe()
+}
+
+func (e *FieldError) describe() string {
+	if e.Type == nil {
+		return fmt.Sprintf("field %s: %s", e.Path, e.Reason)
+	}
+	return fmt.Sprintf("field %s (%s): %s", e.Path, e.Type, e.Reason)
+}
+
+// MappingError is returned in strict mode when some fields couldn't be mapped,
+// and when a mapping refers to analyzers that don't exist. It lists all the
+// problems rather than stopping at the first one.
+type MappingError struct {
+	Fields []*FieldError
+}
+
+func (e *MappingError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.describe()
+	}
+	return fmt.Sprintf("elasticmapper: %d problem(s) with the mapping: %s", len(e.Fields), strings.Join(problems, "; "))
+}
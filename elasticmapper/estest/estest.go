@@ -0,0 +1,763 @@
+// Package estest is a stand-in for an ElasticSearch cluster, for trying out
+// the requests elasticmapper builds without running one. It keeps indices,
+// aliases and documents in memory, checks requests the way ElasticSearch
+// would, and records every one of them.
+//
+// Only the APIs elasticmapper uses are there: creating, getting and deleting
+// indices, refresh, aliases, single documents, _bulk and _reindex. Searches
+// aren't. Mappings are parsed when an index is created, and documents are
+// checked against them with elasticmapper.ValidateDocument when they're
+// written. The only scripts _reindex runs are the renames of an
+// elasticmapper.MigrationPlan; it rejects any other script.
+package estest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+// Request is a request the server got.
+type Request struct {
+	Method string
+	// Path is the path of the request, with any query string.
+	Path string
+	Body []byte
+	// Status is the status of the response.
+	Status int
+}
+
+func (r *Request) String() string {
+	return fmt.Sprintf("%s %s -> %d", r.Method, r.Path, r.Status)
+}
+
+// Server is an in-memory ElasticSearch listening on a local port. Close it
+// when done.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*Request
+	indices  map[string]*index
+	nextID   int
+}
+
+type index struct {
+	settings json.RawMessage
+	mappings json.RawMessage
+	// mapping is mappings parsed, or nil if the index was created without
+	// one.
+	mapping *elasticmapper.Mapping
+	aliases map[string]json.RawMessage
+	docs    map[string]json.RawMessage
+}
+
+// NewServer starts a Server with no indices.
+func NewServer() *Server {
+	s := &Server{indices: make(map[string]*index)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Requests returns the requests the server got so far, in order.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request(nil), s.requests...)
+}
+
+// Indices returns the names of the indices, sorted.
+func (s *Server) Indices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.indices))
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Aliases returns the indices behind alias, sorted.
+func (s *Server) Aliases(alias string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aliased(alias)
+}
+
+// Mappings returns the mappings an index was created with, or nil if there's
+// no such index.
+func (s *Server) Mappings(name string) json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.indices[name]; idx != nil {
+		return idx.mappings
+	}
+	return nil
+}
+
+// Documents returns the documents of an index by _id, or nil if there's no
+// such index.
+func (s *Server) Documents(name string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.indices[name]
+	if idx == nil {
+		return nil
+	}
+	docs := make(map[string]json.RawMessage, len(idx.docs))
+	for id, doc := range idx.docs {
+		docs[id] = doc
+	}
+	return docs
+}
+
+func (s *Server) aliased(alias string) []string {
+	var names []string
+	for name, idx := range s.indices {
+		if _, ok := idx.aliases[alias]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// esError is an error response, in the shape ElasticSearch gives them.
+type esError struct {
+	status int
+	kind   string
+	reason string
+}
+
+func errorf(status int, kind, format string, args ...interface{}) *esError {
+	return &esError{status: status, kind: kind, reason: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, response := http.StatusOK, interface{}(nil)
+	result, e := s.handle(r, body)
+	if e != nil {
+		status = e.status
+		response = map[string]interface{}{
+			"error":  map[string]string{"type": e.kind, "reason": e.reason},
+			"status": e.status,
+		}
+	} else {
+		response = result
+		if m, ok := result.(map[string]interface{}); ok && m["result"] == "created" {
+			status = http.StatusCreated
+		}
+	}
+	s.requests = append(s.requests, &Request{Method: r.Method, Path: r.URL.RequestURI(), Body: body, Status: status})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handle routes a request. It's called with s.mu held.
+func (s *Server) handle(r *http.Request, body []byte) (interface{}, *esError) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	name, api := parts[0], ""
+	if len(parts) > 1 {
+		api = parts[1]
+	}
+	switch {
+	case name == "_aliases" && len(parts) == 1 && r.Method == "POST":
+		return s.updateAliases(body)
+	case name == "_alias" && len(parts) == 2 && r.Method == "GET":
+		return s.getAlias(parts[1])
+	case name == "_reindex" && len(parts) == 1 && r.Method == "POST":
+		return s.reindex(body)
+	case name == "_bulk" && len(parts) == 1 && (r.Method == "POST" || r.Method == "PUT"):
+		return s.bulk("", body, r.Header.Get("Content-Type"))
+	case name == "" || strings.HasPrefix(name, "_"):
+	case len(parts) == 1 && r.Method == "PUT":
+		return s.createIndex(name, body)
+	case len(parts) == 1 && r.Method == "GET":
+		return s.getIndex(name)
+	case len(parts) == 1 && r.Method == "DELETE":
+		return s.deleteIndex(name)
+	case api == "_refresh" && len(parts) == 2 && r.Method == "POST":
+		if _, e := s.resolve(name); e != nil {
+			return nil, e
+		}
+		return map[string]interface{}{"_shards": map[string]int{"failed": 0}}, nil
+	case api == "_bulk" && len(parts) == 2 && (r.Method == "POST" || r.Method == "PUT"):
+		return s.bulk(name, body, r.Header.Get("Content-Type"))
+	case api == "_doc" && len(parts) == 2 && r.Method == "POST":
+		return s.indexDocument(name, "", body)
+	case api == "_doc" && len(parts) == 3 && (r.Method == "PUT" || r.Method == "POST"):
+		return s.indexDocument(name, parts[2], body)
+	case api == "_doc" && len(parts) == 3 && r.Method == "GET":
+		return s.getDocument(name, parts[2])
+	}
+	return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "no handler found for uri [%s] and method [%s]", r.URL.Path, r.Method)
+}
+
+// validName reports why name can't be an index, or "" if it can.
+func validName(name string) string {
+	switch {
+	case name != strings.ToLower(name):
+		return "must be lowercase"
+	case strings.IndexAny(name, `\/*?"<>| ,#:`) >= 0:
+		return `must not contain \, /, *, ?, ", <, >, |, space, comma, # or :`
+	case strings.HasPrefix(name, "_") || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "+"):
+		return "must not start with _, - or +"
+	case name == "." || name == "..":
+		return "must not be . or .."
+	}
+	return ""
+}
+
+// resolve returns the index called name, or the only index behind the alias
+// name.
+func (s *Server) resolve(name string) (*index, *esError) {
+	if idx := s.indices[name]; idx != nil {
+		return idx, nil
+	}
+	switch names := s.aliased(name); len(names) {
+	case 0:
+		return nil, errorf(http.StatusNotFound, "index_not_found_exception", "no such index [%s]", name)
+	case 1:
+		return s.indices[names[0]], nil
+	default:
+		return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "alias [%s] has more than one index associated with it %v, can't execute a single index op", name, names)
+	}
+}
+
+func (s *Server) createIndex(name string, body []byte) (interface{}, *esError) {
+	if reason := validName(name); reason != "" {
+		return nil, errorf(http.StatusBadRequest, "invalid_index_name_exception", "Invalid index name [%s], %s", name, reason)
+	}
+	if s.indices[name] != nil {
+		return nil, errorf(http.StatusBadRequest, "resource_already_exists_exception", "index [%s] already exists", name)
+	}
+	if len(s.aliased(name)) > 0 {
+		return nil, errorf(http.StatusBadRequest, "invalid_index_name_exception", "Invalid index name [%s], already exists as alias", name)
+	}
+	var request struct {
+		Settings json.RawMessage            `json:"settings"`
+		Mappings json.RawMessage            `json:"mappings"`
+		Aliases  map[string]json.RawMessage `json:"aliases"`
+	}
+	if e := decodeStrict(body, &request); e != nil {
+		return nil, e
+	}
+	for _, part := range []json.RawMessage{request.Settings, request.Mappings} {
+		if len(part) > 0 && part[0] != '{' {
+			return nil, errorf(http.StatusBadRequest, "parse_exception", "settings and mappings must be objects")
+		}
+	}
+	var mapping *elasticmapper.Mapping
+	if len(request.Mappings) > 0 {
+		var err error
+		if mapping, err = elasticmapper.ParseMapping(request.Mappings); err != nil {
+			return nil, errorf(http.StatusBadRequest, "mapper_parsing_exception", "Failed to parse mapping: %v", err)
+		}
+	}
+	idx := &index{
+		settings: request.Settings,
+		mappings: request.Mappings,
+		mapping:  mapping,
+		aliases:  make(map[string]json.RawMessage),
+		docs:     make(map[string]json.RawMessage),
+	}
+	for alias, options := range request.Aliases {
+		if s.indices[alias] != nil {
+			return nil, errorf(http.StatusBadRequest, "invalid_alias_name_exception", "Invalid alias name [%s]: an index or data stream exists with the same name as the alias", alias)
+		}
+		idx.aliases[alias] = options
+	}
+	s.indices[name] = idx
+	return map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": name}, nil
+}
+
+func (s *Server) getIndex(name string) (interface{}, *esError) {
+	names := []string{name}
+	if s.indices[name] == nil {
+		if names = s.aliased(name); len(names) == 0 {
+			return nil, errorf(http.StatusNotFound, "index_not_found_exception", "no such index [%s]", name)
+		}
+	}
+	result := make(map[string]interface{})
+	for _, n := range names {
+		idx := s.indices[n]
+		result[n] = map[string]interface{}{
+			"settings": rawOrEmpty(idx.settings),
+			"mappings": rawOrEmpty(idx.mappings),
+			"aliases":  idx.aliases,
+		}
+	}
+	return result, nil
+}
+
+func (s *Server) deleteIndex(name string) (interface{}, *esError) {
+	if s.indices[name] == nil {
+		if len(s.aliased(name)) > 0 {
+			return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "The provided expression [%s] matches an alias, specify the corresponding concrete indices instead.", name)
+		}
+		return nil, errorf(http.StatusNotFound, "index_not_found_exception", "no such index [%s]", name)
+	}
+	delete(s.indices, name)
+	return map[string]interface{}{"acknowledged": true}, nil
+}
+
+func (s *Server) indexDocument(name, id string, body []byte) (interface{}, *esError) {
+	idx, e := s.resolve(name)
+	if e != nil {
+		return nil, e
+	}
+	if !json.Valid(body) || len(bytes.TrimSpace(body)) == 0 || bytes.TrimSpace(body)[0] != '{' {
+		return nil, errorf(http.StatusBadRequest, "mapper_parsing_exception", "failed to parse, the document must be an object")
+	}
+	if reason := idx.check(body); reason != "" {
+		return nil, errorf(http.StatusBadRequest, "document_parsing_exception", "%s", reason)
+	}
+	if id == "" {
+		s.nextID++
+		id = "generated-" + strconv.Itoa(s.nextID)
+	}
+	result := "created"
+	if _, ok := idx.docs[id]; ok {
+		result = "updated"
+	}
+	idx.docs[id] = append(json.RawMessage(nil), body...)
+	return map[string]interface{}{"_index": s.nameOf(idx), "_id": id, "result": result}, nil
+}
+
+func (s *Server) getDocument(name, id string) (interface{}, *esError) {
+	idx, e := s.resolve(name)
+	if e != nil {
+		return nil, e
+	}
+	doc, ok := idx.docs[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "not_found", "document [%s] not found", id)
+	}
+	return map[string]interface{}{"_index": s.nameOf(idx), "_id": id, "found": true, "_source": doc}, nil
+}
+
+func (s *Server) nameOf(idx *index) string {
+	for name, i := range s.indices {
+		if i == idx {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *Server) updateAliases(body []byte) (interface{}, *esError) {
+	var request struct {
+		Actions []map[string]struct {
+			Index string `json:"index"`
+			Alias string `json:"alias"`
+		} `json:"actions"`
+	}
+	if e := decodeStrict(body, &request); e != nil {
+		return nil, e
+	}
+	if len(request.Actions) == 0 {
+		return nil, errorf(http.StatusBadRequest, "action_request_validation_exception", "Validation Failed: 1: no actions;")
+	}
+	// All actions happen at once or not at all, so work on a copy
+	aliases := make(map[string]map[string]json.RawMessage)
+	for name, idx := range s.indices {
+		aliases[name] = make(map[string]json.RawMessage)
+		for alias, options := range idx.aliases {
+			aliases[name][alias] = options
+		}
+	}
+	var removed []string
+	for _, action := range request.Actions {
+		if len(action) != 1 {
+			return nil, errorf(http.StatusBadRequest, "parse_exception", "each alias action must have exactly one of add, remove and remove_index")
+		}
+		for kind, target := range action {
+			if target.Index == "" || kind != "remove_index" && target.Alias == "" {
+				return nil, errorf(http.StatusBadRequest, "action_request_validation_exception", "Validation Failed: 1: [%s] requires an index and an alias;", kind)
+			}
+			current, ok := aliases[target.Index]
+			if !ok {
+				return nil, errorf(http.StatusNotFound, "index_not_found_exception", "no such index [%s]", target.Index)
+			}
+			switch kind {
+			case "add":
+				if s.indices[target.Alias] != nil {
+					return nil, errorf(http.StatusBadRequest, "invalid_alias_name_exception", "Invalid alias name [%s]: an index or data stream exists with the same name as the alias", target.Alias)
+				}
+				current[target.Alias] = json.RawMessage("{}")
+			case "remove":
+				if _, ok := current[target.Alias]; !ok {
+					return nil, errorf(http.StatusNotFound, "aliases_not_found_exception", "aliases [%s] missing", target.Alias)
+				}
+				delete(current, target.Alias)
+			case "remove_index":
+				delete(aliases, target.Index)
+				removed = append(removed, target.Index)
+			default:
+				return nil, errorf(http.StatusBadRequest, "parse_exception", "unknown alias action [%s]", kind)
+			}
+		}
+	}
+	for name, idx := range s.indices {
+		idx.aliases = aliases[name]
+	}
+	for _, name := range removed {
+		delete(s.indices, name)
+	}
+	return map[string]interface{}{"acknowledged": true}, nil
+}
+
+func (s *Server) getAlias(alias string) (interface{}, *esError) {
+	names := s.aliased(alias)
+	if len(names) == 0 {
+		return nil, errorf(http.StatusNotFound, "aliases_not_found_exception", "alias [%s] missing", alias)
+	}
+	result := make(map[string]interface{})
+	for _, name := range names {
+		result[name] = map[string]interface{}{"aliases": map[string]json.RawMessage{alias: s.indices[name].aliases[alias]}}
+	}
+	return result, nil
+}
+
+func (s *Server) reindex(body []byte) (interface{}, *esError) {
+	var request struct {
+		Source struct {
+			Index string `json:"index"`
+		} `json:"source"`
+		Dest struct {
+			Index string `json:"index"`
+		} `json:"dest"`
+		Script    json.RawMessage `json:"script"`
+		Conflicts string          `json:"conflicts"`
+	}
+	if e := decodeStrict(body, &request); e != nil {
+		return nil, e
+	}
+	source, e := s.resolve(request.Source.Index)
+	if e != nil {
+		return nil, e
+	}
+	// ElasticSearch would create a missing destination with a dynamic
+	// mapping, which is never what a migration wants
+	dest := s.indices[request.Dest.Index]
+	if dest == nil {
+		return nil, errorf(http.StatusNotFound, "index_not_found_exception", "no such index [%s]; reindexing would create it with a dynamic mapping", request.Dest.Index)
+	}
+	if source == dest {
+		return nil, errorf(http.StatusBadRequest, "action_request_validation_exception", "reindex cannot write into an index its reading from [%s]", request.Dest.Index)
+	}
+	var moves []move
+	if len(request.Script) > 0 {
+		var script struct {
+			Lang   string `json:"lang"`
+			Source string `json:"source"`
+		}
+		if e := decodeStrict(request.Script, &script); e != nil {
+			return nil, e
+		}
+		var err error
+		if moves, err = parseMoves(script.Source); err != nil {
+			return nil, errorf(http.StatusBadRequest, "script_exception", "estest: %v", err)
+		}
+	}
+	ids := make([]string, 0, len(source.docs))
+	for id := range source.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	created, updated := 0, 0
+	failures := []interface{}{}
+	for _, id := range ids {
+		doc := source.docs[id]
+		if len(moves) > 0 {
+			var fields map[string]interface{}
+			dec := json.NewDecoder(bytes.NewReader(doc))
+			dec.UseNumber()
+			if err := dec.Decode(&fields); err != nil {
+				return nil, errorf(http.StatusInternalServerError, "exception", "%v", err)
+			}
+			for _, m := range moves {
+				m.apply(fields)
+			}
+			doc, _ = json.Marshal(fields)
+		}
+		if reason := dest.check(doc); reason != "" {
+			failures = append(failures, map[string]interface{}{
+				"index": request.Dest.Index,
+				"id":    id,
+				"cause": map[string]string{"type": "document_parsing_exception", "reason": reason},
+			})
+			continue
+		}
+		if _, ok := dest.docs[id]; ok {
+			updated++
+		} else {
+			created++
+		}
+		dest.docs[id] = doc
+	}
+	return map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"total":     len(source.docs),
+		"created":   created,
+		"updated":   updated,
+		"failures":  failures,
+	}, nil
+}
+
+// check returns why ElasticSearch would reject doc for idx, or "".
+func (idx *index) check(doc []byte) string {
+	if idx.mapping == nil {
+		return ""
+	}
+	err := elasticmapper.ValidateDocument(idx.mapping, doc)
+	if documentErr, ok := err.(*elasticmapper.DocumentError); ok && !documentErr.Rejected() {
+		// Accepted, only indexed differently than it's written
+		return ""
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// move is a call of the move function in the reindex script of a
+// MigrationPlan, which moves the value at one path to another.
+type move struct {
+	from, to []string
+}
+
+var moveCall = regexp.MustCompile(`^move\(ctx\._source, (\[.*\]), (\[.*\])\);$`)
+
+// parseMoves finds the moves in the source of a reindex script, which must
+// only define the move function and call it.
+func parseMoves(source string) ([]move, error) {
+	var moves []move
+	inFunction := false
+	for _, line := range strings.Split(source, "\n") {
+		switch {
+		case inFunction:
+			inFunction = line != "}"
+		case strings.HasPrefix(line, "void move(Map source, List from, List to) {"):
+			inFunction = true
+		case strings.TrimSpace(line) == "":
+		default:
+			call := moveCall.FindStringSubmatch(line)
+			if call == nil {
+				return nil, fmt.Errorf("can't run script line %q; only the renames of a migration plan are supported", line)
+			}
+			from, err := parsePainlessList(call[1])
+			if err != nil {
+				return nil, err
+			}
+			to, err := parsePainlessList(call[2])
+			if err != nil {
+				return nil, err
+			}
+			moves = append(moves, move{from, to})
+		}
+	}
+	return moves, nil
+}
+
+// parsePainlessList parses a list literal of single-quoted strings, like
+// ['a', 'it\'s'].
+func parsePainlessList(list string) ([]string, error) {
+	var items []string
+	rest := strings.TrimSuffix(strings.TrimPrefix(list, "["), "]")
+	for rest != "" {
+		if rest[0] != '\'' {
+			return nil, fmt.Errorf("bad list %s", list)
+		}
+		var item strings.Builder
+		i := 1
+		for ; i < len(rest) && rest[i] != '\''; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+			}
+			item.WriteByte(rest[i])
+		}
+		if i == len(rest) {
+			return nil, fmt.Errorf("bad list %s", list)
+		}
+		items = append(items, item.String())
+		rest = strings.TrimPrefix(rest[i+1:], ", ")
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("empty list %s", list)
+	}
+	return items, nil
+}
+
+// apply does what the painless move function does to a document.
+func (m move) apply(doc map[string]interface{}) {
+	parent := doc
+	for _, key := range m.from[:len(m.from)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		parent = next
+	}
+	last := m.from[len(m.from)-1]
+	value, ok := parent[last]
+	if !ok {
+		return
+	}
+	delete(parent, last)
+	parent = doc
+	for _, key := range m.to[:len(m.to)-1] {
+		next, ok := parent[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			parent[key] = next
+		}
+		parent = next
+	}
+	parent[m.to[len(m.to)-1]] = value
+}
+
+func (s *Server) bulk(defaultIndex string, body []byte, contentType string) (interface{}, *esError) {
+	if !strings.HasPrefix(contentType, "application/x-ndjson") && !strings.HasPrefix(contentType, "application/json") {
+		return nil, errorf(http.StatusNotAcceptable, "media_type_header_exception", "Content-Type header [%s] is not supported", contentType)
+	}
+	if len(body) == 0 || body[len(body)-1] != '\n' {
+		return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "The bulk request must be terminated by a newline [\\n]")
+	}
+	var items []interface{}
+	hasErrors := false
+	lines := bufio.NewScanner(bytes.NewReader(body))
+	lines.Buffer(nil, len(body)+1)
+	for lines.Scan() {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(lines.Bytes(), &action); err != nil || len(action) != 1 {
+			return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "Malformed action/metadata line [%s]", lines.Text())
+		}
+		for kind, meta := range action {
+			var source []byte
+			if kind != "delete" {
+				if !lines.Scan() {
+					return nil, errorf(http.StatusBadRequest, "illegal_argument_exception", "%s action is missing its source", kind)
+				}
+				source = append([]byte(nil), lines.Bytes()...)
+			}
+			name := meta.Index
+			if name == "" {
+				name = defaultIndex
+			}
+			status, result := s.bulkItem(kind, name, meta.ID, source)
+			item := map[string]interface{}{"_index": name, "_id": meta.ID, "status": status}
+			if status >= 300 {
+				hasErrors = true
+				item["error"] = map[string]string{"type": "document_error", "reason": result}
+			} else {
+				item["result"] = result
+			}
+			items = append(items, map[string]interface{}{kind: item})
+		}
+	}
+	return map[string]interface{}{"took": 1, "errors": hasErrors, "items": items}, nil
+}
+
+// bulkItem carries out one action of a bulk request, and returns its status
+// and result, or the reason it failed.
+func (s *Server) bulkItem(kind, name, id string, source []byte) (int, string) {
+	idx, e := s.resolve(name)
+	if e != nil {
+		return e.status, e.reason
+	}
+	_, exists := idx.docs[id]
+	switch kind {
+	case "index", "create":
+		if kind == "create" && exists {
+			return http.StatusConflict, fmt.Sprintf("[%s]: version conflict, document already exists", id)
+		}
+		if reason := idx.check(source); reason != "" {
+			return http.StatusBadRequest, reason
+		}
+		if id == "" {
+			s.nextID++
+			id = "generated-" + strconv.Itoa(s.nextID)
+		}
+		idx.docs[id] = source
+		if exists {
+			return http.StatusOK, "updated"
+		}
+		return http.StatusCreated, "created"
+	case "update":
+		var update struct {
+			Doc         map[string]json.RawMessage `json:"doc"`
+			DocAsUpsert bool                       `json:"doc_as_upsert"`
+		}
+		if err := json.Unmarshal(source, &update); err != nil || update.Doc == nil {
+			return http.StatusBadRequest, "update without a doc"
+		}
+		if !exists && !update.DocAsUpsert {
+			return http.StatusNotFound, fmt.Sprintf("[%s]: document missing", id)
+		}
+		doc := make(map[string]json.RawMessage)
+		if exists {
+			json.Unmarshal(idx.docs[id], &doc)
+		}
+		for field, value := range update.Doc {
+			doc[field] = value
+		}
+		idx.docs[id], _ = json.Marshal(doc)
+		if exists {
+			return http.StatusOK, "updated"
+		}
+		return http.StatusCreated, "created"
+	case "delete":
+		if !exists {
+			return http.StatusNotFound, "not_found"
+		}
+		delete(idx.docs, id)
+		return http.StatusOK, "deleted"
+	}
+	return http.StatusBadRequest, fmt.Sprintf("unknown action [%s]", kind)
+}
+
+// decodeStrict decodes a JSON body into v, failing on fields v doesn't have
+// the way ElasticSearch fails on unknown parameters. An empty body is fine.
+func decodeStrict(body []byte, v interface{}) *esError {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "parse_exception", "%v", err)
+	}
+	return nil
+}
+
+func rawOrEmpty(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
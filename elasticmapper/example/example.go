@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"reflect"
 	"time"
 
 	"github.com/wingedrhino/golang-snippets/elasticmapper"
+	"github.com/wingedrhino/golang-snippets/elasticmapper/estest"
 )
 
 // MyType is a sample type for testing purposes
@@ -12,12 +18,59 @@ type MyType struct {
 	A string `json:"a" xml:"AElement" elasticmapper:"-"`
 	B int64
 	M MyType2
-	N MyType3 `elasticmapper:"world,hello"`
-	O bool    `elasticmapper:"hello,     world" json:"-"`
+	N MyType3
+	O bool `elasticmapper:"boolean,     index=false" json:"-"`
 	P []MyType3
 	Q []uint64
 	R time.Time
 	S []time.Time
+	T string `json:"t,omitempty"`
+	MyEmbedded
+	U *time.Time
+	V *MyType2
+	W map[string]MyType3
+	X map[string]string `elasticmapper:"flattened"`
+	Y fmt.Stringer      `elasticmapper:"keyword"`
+	Z interface{}
+	// Channels can't be mapped, so this shows up as a warning
+	Done chan struct{}
+	Tree MyNode
+	// Types the mapper knows about, registered and mapping themselves
+	Addr     net.IP
+	Timeout  time.Duration
+	Avatar   []byte
+	Price    Decimal
+	Location GeoPoint
+}
+
+// Decimal stands in for a fixed point type like decimal.Decimal, which is
+// written as a string but should be indexed as a number. See main for how it's
+// registered.
+type Decimal struct {
+	units    int64
+	exponent int32
+}
+
+// GeoPoint is a sample type that knows its own mapping
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// ElasticProperty implements elasticmapper.Mapper
+func (GeoPoint) ElasticProperty() *elasticmapper.Property {
+	return &elasticmapper.Property{Type: "geo_point"}
+}
+
+// MyNode is a sample type that contains itself
+type MyNode struct {
+	Name     string
+	Children []MyNode
+}
+
+// MyEmbedded is promoted into MyType just like encoding/json does it
+type MyEmbedded struct {
+	E string `json:"e" elasticmapper:"text"`
 }
 
 // MyType2 is a sample type for testing purposes
@@ -27,16 +80,283 @@ type MyType2 struct {
 
 // MyType3 is a sample type for testing purposes
 type MyType3 struct {
-	C string `elasticmapper:"hello"`
-	D int64  `elasticmapper:"idonothing"`
+	C string `elasticmapper:"text,analyzer=english,search_analyzer=standard,fields.raw=keyword,copy_to=all_text"`
+	D int64  `elasticmapper:"date,format=epoch_millis"`
+}
+
+// MyType4 is a sample type for testing custom analyzers. Its mapping is kept
+// in my_type4.mapping.json by running `elasticmapper gen` in this directory.
+//
+//elasticmapper:generate target=es8
+type MyType4 struct {
+	Name string `json:"name" elasticmapper:"text,analyzer=autocomplete,search_analyzer=standard,fields.raw=keyword"`
+	Code string `json:"code" elasticmapper:"keyword,normalizer=lowercase"`
+}
+
+// MyType5 is a sample type for testing precise numbers and ranges
+type MyType5 struct {
+	Age    int8
+	Stock  uint32
+	Serial uint64
+	Ratio  float32
+	Price  float64  `elasticmapper:"scaled_float,scaling_factor=100"`
+	Score  float32  `elasticmapper:"half_float"`
+	Ages   MyRange  `elasticmapper:"integer_range"`
+	Open   MyPeriod `elasticmapper:"date_range,format=strict_date"`
+}
+
+// MyRange is a sample type holding the bounds of a range field
+type MyRange struct {
+	Gte int `json:"gte"`
+	Lte int `json:"lte"`
+}
+
+// MyPeriod is a sample type holding the bounds of a date range field
+type MyPeriod struct {
+	Gte string `json:"gte"`
+	Lt  string `json:"lt,omitempty"`
+}
+
+// MyType6 is a sample type for testing geo, suggestion and vector fields
+type MyType6 struct {
+	Where     [2]float64 `elasticmapper:"geo_point"`
+	Near      elasticmapper.GeoPoint
+	Area      map[string]interface{} `elasticmapper:"geo_shape"`
+	Suggest   []string               `elasticmapper:"completion,analyzer=simple,context.genre=category:Genre"`
+	Genre     string
+	Title     string       `elasticmapper:"search_as_you_type,max_shingle_size=3"`
+	Embedding [384]float32 `elasticmapper:"dense_vector,similarity=cosine"`
+}
+
+// MyQuestion is a sample type for testing join fields and the ways slices of
+// structs can be mapped
+type MyQuestion struct {
+	_        elasticmapper.Object `elasticmapper:"dynamic=strict"`
+	Join     elasticmapper.Join   `json:"join" elasticmapper:"join,relation=question"`
+	Title    string               `json:"title" elasticmapper:"text"`
+	Tags     []MyType2            `json:"tags" elasticmapper:"object"`
+	Votes    []MyVote             `json:"votes" elasticmapper:"nested,include_in_parent=true"`
+	Internal MyType3              `json:"internal" elasticmapper:"enabled=false"`
+	Notes    MyType2              `json:"notes" elasticmapper:"dynamic=false"`
+	Labels   map[string]string    `json:"labels"`
+}
+
+// MyAnswer is a sample type for testing join fields
+type MyAnswer struct {
+	ID       string             `json:"-" elasticmapper:"_id"`
+	Question string             `json:"-" elasticmapper:"_routing"`
+	Join     elasticmapper.Join `json:"join" elasticmapper:"join,relation=answer,parent=question"`
+	Body     string             `json:"body" elasticmapper:"text"`
+}
+
+// MyVote is a sample type for testing nested fields
+type MyVote struct {
+	User string `json:"user"`
+	Up   bool   `json:"up"`
+}
+
+// MyPerson is a sample type for testing migrations
+type MyPerson struct {
+	ID   string `json:"-" elasticmapper:"_id"`
+	Name string `json:"name"`
+	Mail string `json:"mail"`
+}
+
+// MyPersonV2 is the next version of MyPerson, with a searchable name and a
+// renamed field
+type MyPersonV2 struct {
+	ID    string `json:"-" elasticmapper:"_id"`
+	Name  string `json:"name" elasticmapper:"text,fields.raw=keyword"`
+	Email string `json:"email"`
 }
 
 func main() {
+	ignoreAbove := 256
+	elasticmapper.Register(reflect.TypeOf(Decimal{}), &elasticmapper.Property{Type: "double"})
 	myVar := MyType{A: "Hello World!", B: 123123123, M: MyType2{"Ouch"}, N: MyType3{"Yieks!", 13}}
 	fmt.Printf("Variable myVar: %v\n", myVar)
-	mapping, err := elasticmapper.GetElasticMapping(myVar, "MyType")
+	result, err := elasticmapper.Map(myVar, elasticmapper.Options{
+		Target: elasticmapper.Elasticsearch8,
+		InterfaceTypes: map[reflect.Type]reflect.Type{
+			reflect.TypeOf((*interface{})(nil)).Elem(): reflect.TypeOf(MyType2{}),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("Warning: %v\n", warning)
+	}
+	// The mapping can be changed before it's rendered
+	result.Mapping.Property("N.C").Fields["raw"].IgnoreAbove = &ignoreAbove
+	mapping, err := json.MarshalIndent(result.Mapping, "", "  ")
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Generated Mapping:\n\n%s\n\n", mapping)
+
+	// Numbers mapped to the smallest type that holds them
+	precise, err := elasticmapper.GetElasticMappingWithOptions(MyType5{}, "", elasticmapper.Options{
+		Target:         elasticmapper.Elasticsearch8,
+		PreciseNumbers: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Precise Mapping:\n\n%s\n\n", precise)
+
+	// Geo, suggestion and vector fields, with dims taken from the array
+	search, err := elasticmapper.GetElasticMappingWithOptions(MyType6{}, "", elasticmapper.Options{
+		Target: elasticmapper.Elasticsearch8,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Search Mapping:\n\n%s\n\n", search)
+
+	// Questions and answers in one index, joined as parent and child
+	result, err = elasticmapper.MapAll(elasticmapper.Options{Target: elasticmapper.Elasticsearch8}, MyQuestion{}, MyAnswer{})
+	if err != nil {
+		panic(err)
+	}
+	joined, err := json.MarshalIndent(result.Mapping, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Joined Mapping:\n\n%s\n\n", joined)
+
+	// The same types for other search backends
+	opensearch, err := elasticmapper.Generate(MyType6{}, elasticmapper.Options{}, &elasticmapper.OpenSearchEmitter{})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("OpenSearch Mapping:\n\n%s\n\n", opensearch)
+	solr, err := (&elasticmapper.SolrEmitter{}).Emit(result.Mapping)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Solr Fields:\n\n%s\n", solr)
+	meilisearch, err := elasticmapper.Generate(MyType4{}, elasticmapper.Options{}, &elasticmapper.MeilisearchEmitter{})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Meilisearch Settings:\n\n%s\n\n", meilisearch)
+
+	// Documents checked against the joined mapping before they're indexed
+	document := `{"join": {"name": "answer"}, "title": "Why?", "votes": [{"user": "me", "up": "yes"}], "score": 3}`
+	if err := elasticmapper.ValidateDocument(result.Mapping, []byte(document)); err != nil {
+		fmt.Printf("Invalid Document:\n\n")
+		for _, problem := range err.(*elasticmapper.DocumentError).Values {
+			fmt.Printf("%s: %s\n", problem.Pointer, problem.Reason)
+		}
+		fmt.Println()
+	}
+
+	// Answers written for the bulk API, routed to the shard of their question
+	bulk := elasticmapper.NewBulkEncoder(elasticmapper.BulkOptions{Index: "questions", MaxActions: 2}, func(batch []byte, actions int) error {
+		fmt.Printf("Bulk Request (%d actions):\n\n%s\n", actions, batch)
+		return nil
+	})
+	for i, body := range []string{"Because.", "Why not?", "42"} {
+		answer := MyAnswer{
+			ID:       fmt.Sprintf("a%d", i),
+			Question: "q1",
+			Join:     elasticmapper.Join{Name: "answer", Parent: "q1"},
+			Body:     body,
+		}
+		if err := bulk.Index(answer); err != nil {
+			panic(err)
+		}
+	}
+	if err := bulk.Flush(); err != nil {
+		panic(err)
+	}
+
+	// A whole index, with a custom analyzer the tags of MyType4 refer to
+	replicas := 1
+	body, err := elasticmapper.GetCreateIndexBody(MyType4{}, "", elasticmapper.Index{
+		Settings: elasticmapper.Settings{
+			NumberOfShards:   3,
+			NumberOfReplicas: &replicas,
+			Analysis: &elasticmapper.Analysis{
+				Analyzers: map[string]*elasticmapper.Analyzer{
+					"autocomplete": {Tokenizer: "autocomplete", Filter: []string{"lowercase"}},
+				},
+				Tokenizers: map[string]elasticmapper.Component{
+					"autocomplete": {"type": "edge_ngram", "min_gram": 2, "max_gram": 10},
+				},
+			},
+		},
+		Aliases: map[string]*elasticmapper.Alias{"things": {}},
+	}, elasticmapper.Options{Target: elasticmapper.Elasticsearch8})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Create Index Body:\n\n%s\n\n", body)
+
+	// What it takes to move an existing index over to the mapping of MyType4
+	live := `{"things-v1": {"mappings": {"properties": {"name": {"type": "text"}, "code": {"type": "long"}}}}}`
+	result, err = elasticmapper.Map(MyType4{}, elasticmapper.Options{Target: elasticmapper.Elasticsearch8})
+	if err != nil {
+		panic(err)
+	}
+	diff, err := elasticmapper.CheckCompatibility(result.Mapping, []byte(live))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Changes from the live mapping (%s):\n", diff.Compatibility())
+	for _, change := range diff.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+
+	// Moving MyPerson over to MyPersonV2 behind the people alias, tried out
+	// on a stand-in server
+	server := estest.NewServer()
+	defer server.Close()
+	opts := elasticmapper.Options{Target: elasticmapper.Elasticsearch8}
+	plan, err := elasticmapper.PlanMigration(nil, MyPerson{}, elasticmapper.Migration{Alias: "people", To: "people_v1", Options: opts})
+	if err != nil {
+		panic(err)
+	}
+	if err := plan.Execute(nil, server.URL); err != nil {
+		panic(err)
+	}
+	bulk = elasticmapper.NewBulkEncoder(elasticmapper.BulkOptions{Index: "people"}, func(batch []byte, actions int) error {
+		resp, err := http.Post(server.URL+"/_bulk", "application/x-ndjson", bytes.NewReader(batch))
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+	for _, person := range []MyPerson{{"1", "Ada", "ada@example.com"}, {"2", "Alan", "alan@example.com"}} {
+		if err := bulk.Index(person); err != nil {
+			panic(err)
+		}
+	}
+	if err := bulk.Flush(); err != nil {
+		panic(err)
+	}
+	plan, err = elasticmapper.PlanMigration(MyPerson{}, MyPersonV2{}, elasticmapper.Migration{
+		Alias:   "people",
+		From:    "people_v1",
+		To:      "people_v2",
+		Options: opts,
+		Renames: map[string]string{"mail": "email"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("\nMigration (%s):\n\n", plan.Diff.Compatibility())
+	for _, request := range plan.Requests {
+		fmt.Printf("# %s\n%s\n\n", request.Description, request)
+	}
+	if err := plan.Execute(nil, server.URL); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Requests sent:\n")
+	for _, request := range server.Requests() {
+		fmt.Printf("  %s\n", request)
+	}
+	fmt.Printf("people is now %v, holding %d documents\n", server.Aliases("people"), len(server.Documents("people_v2")))
+	fmt.Printf("Document 1 after the rename: %s\n", server.Documents("people_v2")["1"])
 }
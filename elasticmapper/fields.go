@@ -0,0 +1,195 @@
+package elasticmapper
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// field is a struct field as encoding/json would see it: promoted out of any
+// embedded structs and named the way it is stored in the document.
+type field struct {
+	name   string
+	tagged bool
+	index  []int
+	sf     reflect.StructField
+}
+
+// typeFields returns the fields of struct type t that encoding/json would
+// serialize, in declaration order. It follows the same rules as encoding/json:
+// unexported fields and fields tagged `json:"-"` are skipped, the name in the
+// json tag wins over the Go name, and fields of untagged embedded structs are
+// promoted into the parent, with the shallowest (or only tagged) field winning
+// when several share a name. Options such as omitempty don't change the
+// mapping and are ignored. If useGoNames is set, the Go field name is used
+// even when a json tag renames the field.
+func typeFields(t reflect.Type, useGoNames bool) []field {
+	var current []field
+	next := []field{{sf: reflect.StructField{Type: t}}}
+
+	// Number of times each embedded type was seen at the current and next
+	// depth. Seeing a type twice at one depth makes its fields ambiguous.
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+	visited := map[reflect.Type]bool{}
+
+	var fields []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			structType := f.sf.Type
+			if visited[structType] {
+				continue
+			}
+			visited[structType] = true
+
+			for i := 0; i < structType.NumField(); i++ {
+				sf := structType.Field(i)
+				if sf.Anonymous {
+					embedded := sf.Type
+					if embedded.Kind() == reflect.Ptr {
+						embedded = embedded.Elem()
+					}
+					// Unexported embedded non-structs are ignored, but
+					// exported fields of unexported embedded structs are not.
+					if sf.PkgPath != "" && embedded.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name := jsonTagName(tag)
+				if !isValidJSONName(name) {
+					name = ""
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				fieldType := sf.Type
+				if fieldType.Name() == "" && fieldType.Kind() == reflect.Ptr {
+					fieldType = fieldType.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || fieldType.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" || useGoNames {
+						name = sf.Name
+					}
+					fields = append(fields, field{name: name, tagged: tagged, index: index, sf: sf})
+					if count[structType] > 1 {
+						// The same embedded type showed up twice at this
+						// depth, so this field is ambiguous. Adding it a
+						// second time makes dominantField drop it.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				// Untagged embedded struct: look at its fields next round.
+				nextCount[fieldType]++
+				if nextCount[fieldType] == 1 {
+					next = append(next, field{
+						name:  fieldType.Name(),
+						index: index,
+						sf:    reflect.StructField{Name: sf.Name, Type: fieldType},
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return indexLess(x[i].index, x[j].index)
+	})
+
+	// Keep only the dominant field for every name.
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fields[i])
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// dominantField picks the field that wins among fields sharing a name. The
+// fields are sorted by depth and then by taggedness, so the first one wins
+// unless another one is just as shallow and just as tagged.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, ak := range a {
+		if k >= len(b) {
+			return false
+		}
+		if ak != b[k] {
+			return ak < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// jsonTagName returns the name part of a json struct tag, which is everything
+// before the first comma.
+func jsonTagName(tag string) string {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx]
+	}
+	return tag
+}
+
+// isValidJSONName reports whether encoding/json would accept name as a key
+// from a struct tag. Invalid names fall back to the Go field name.
+func isValidJSONName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but otherwise any
+			// punctuation chars are allowed in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,3 @@
+module github.com/wingedrhino/golang-snippets/elasticmapper
+
+go 1.22
@@ -0,0 +1,324 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Index is everything that goes into creating an index besides the mapping:
+// its settings, including custom analyzers, and its aliases.
+type Index struct {
+	Settings Settings
+	Aliases  map[string]*Alias
+}
+
+// Settings are the settings of an index. Settings without a field of their own
+// go into Extra, e.g. "refresh_interval".
+type Settings struct {
+	NumberOfShards   int       `json:"number_of_shards,omitempty"`
+	NumberOfReplicas *int      `json:"number_of_replicas,omitempty"`
+	Analysis         *Analysis `json:"analysis,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// settings is Settings without its methods.
+type settings Settings
+
+// MarshalJSON writes s including the settings in s.Extra.
+func (s *Settings) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*settings)(s), s.Extra)
+}
+
+// UnmarshalJSON reads s, keeping unknown settings in s.Extra.
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	*s = Settings{}
+	extra, err := unmarshalWithExtra(data, (*settings)(s))
+	s.Extra = extra
+	return err
+}
+
+// Analysis declares the custom analyzers of an index and their building
+// blocks. Fields refer to analyzers and normalizers by name in their tags,
+// e.g. `elasticmapper:"text,analyzer=autocomplete"`.
+type Analysis struct {
+	Analyzers   map[string]*Analyzer `json:"analyzer,omitempty"`
+	Normalizers map[string]*Analyzer `json:"normalizer,omitempty"`
+	Tokenizers  map[string]Component `json:"tokenizer,omitempty"`
+	Filters     map[string]Component `json:"filter,omitempty"`
+	CharFilters map[string]Component `json:"char_filter,omitempty"`
+}
+
+// Analyzer is a custom analyzer or normalizer. Normalizers have no tokenizer.
+// Parameters of built-in analyzer types, like "stopwords" for the standard
+// analyzer, go into Extra.
+type Analyzer struct {
+	Type       string   `json:"type,omitempty"`
+	Tokenizer  string   `json:"tokenizer,omitempty"`
+	Filter     []string `json:"filter,omitempty"`
+	CharFilter []string `json:"char_filter,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// analyzer is Analyzer without its methods.
+type analyzer Analyzer
+
+// MarshalJSON writes a including the parameters in a.Extra.
+func (a *Analyzer) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*analyzer)(a), a.Extra)
+}
+
+// UnmarshalJSON reads a, keeping unknown parameters in a.Extra.
+func (a *Analyzer) UnmarshalJSON(data []byte) error {
+	*a = Analyzer{}
+	extra, err := unmarshalWithExtra(data, (*analyzer)(a))
+	a.Extra = extra
+	return err
+}
+
+// Component is the definition of a tokenizer, token filter or character
+// filter, such as {"type": "edge_ngram", "min_gram": 2, "max_gram": 10}.
+type Component map[string]interface{}
+
+// Alias is an alias of an index.
+type Alias struct {
+	Filter        interface{} `json:"filter,omitempty"`
+	Routing       string      `json:"routing,omitempty"`
+	IndexRouting  string      `json:"index_routing,omitempty"`
+	SearchRouting string      `json:"search_routing,omitempty"`
+	IsWriteIndex  *bool       `json:"is_write_index,omitempty"`
+	IsHidden      *bool       `json:"is_hidden,omitempty"`
+}
+
+// IndexBody is the body of a create index request. It's also what goes into
+// index templates.
+type IndexBody struct {
+	Settings *Settings         `json:"settings,omitempty"`
+	Mappings interface{}       `json:"mappings,omitempty"`
+	Aliases  map[string]*Alias `json:"aliases,omitempty"`
+}
+
+// The analysis building blocks that come with ElasticSearch and OpenSearch,
+// which don't need to be declared before fields or analyzers use them.
+var (
+	builtinAnalyzers = stringSet(
+		"standard", "simple", "whitespace", "stop", "keyword", "pattern", "fingerprint",
+		"arabic", "armenian", "basque", "bengali", "brazilian", "bulgarian", "catalan",
+		"cjk", "czech", "danish", "dutch", "english", "estonian", "finnish", "french",
+		"galician", "german", "greek", "hindi", "hungarian", "indonesian", "irish",
+		"italian", "latvian", "lithuanian", "norwegian", "persian", "portuguese",
+		"romanian", "russian", "sorani", "spanish", "swedish", "turkish", "thai",
+	)
+	builtinNormalizers = stringSet("lowercase")
+	builtinTokenizers  = stringSet(
+		"standard", "letter", "lowercase", "whitespace", "uax_url_email", "classic",
+		"thai", "ngram", "edge_ngram", "keyword", "pattern", "simple_pattern",
+		"char_group", "simple_pattern_split", "path_hierarchy",
+	)
+	builtinTokenFilters = stringSet(
+		"apostrophe", "asciifolding", "cjk_bigram", "cjk_width", "classic",
+		"common_grams", "condition", "decimal_digit", "delimited_payload",
+		"dictionary_decompounder", "edge_ngram", "elision", "fingerprint",
+		"flatten_graph", "hunspell", "hyphenation_decompounder", "keep_types", "keep",
+		"keyword_marker", "keyword_repeat", "kstem", "length", "limit", "lowercase",
+		"min_hash", "multiplexer", "ngram", "pattern_capture", "pattern_replace",
+		"phonetic", "porter_stem", "predicate_token_filter", "remove_duplicates",
+		"reverse", "shingle", "snowball", "stemmer", "stemmer_override", "stop",
+		"synonym", "synonym_graph", "trim", "truncate", "unique", "uppercase",
+		"word_delimiter", "word_delimiter_graph", "arabic_normalization",
+		"german_normalization", "hindi_normalization", "indic_normalization",
+		"persian_normalization", "scandinavian_folding", "scandinavian_normalization",
+		"serbian_normalization", "sorani_normalization",
+	)
+	builtinCharFilters = stringSet("html_strip", "mapping", "pattern_replace")
+)
+
+func stringSet(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// NewIndexBody puts mapping and index together into the body of a create index
+// request for target. It fails with a *MappingError if a field uses an
+// analyzer or normalizer that is neither built in nor declared in
+// index.Settings.Analysis, or if a custom analyzer or normalizer uses an
+// unknown tokenizer, filter or char_filter.
+func NewIndexBody(mapping *Mapping, typeName string, index Index, target Target) (*IndexBody, error) {
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+	if index.Settings.NumberOfShards < 0 {
+		return nil, errors.New("elasticmapper: number_of_shards can't be negative")
+	}
+	if r := index.Settings.NumberOfReplicas; r != nil && *r < 0 {
+		return nil, errors.New("elasticmapper: number_of_replicas can't be negative")
+	}
+	if problems := checkAnalysis(mapping, index.Settings.Analysis); len(problems) > 0 {
+		return nil, &MappingError{Fields: problems}
+	}
+	body := &IndexBody{Mappings: target.wrap(mapping, typeName), Aliases: index.Aliases}
+	settings := index.Settings
+	if settings.NumberOfShards != 0 || settings.NumberOfReplicas != nil || settings.Analysis != nil || len(settings.Extra) > 0 {
+		body.Settings = &settings
+	}
+	return body, nil
+}
+
+// GetCreateIndexBody is GetElasticMappingWithOptions for a whole index: it
+// returns the body of a create index request with the mapping for input along
+// with the settings and aliases in index.
+func GetCreateIndexBody(input interface{}, typeName string, index Index, opts Options) (string, error) {
+	result, err := Map(input, opts)
+	if err != nil {
+		return "", err
+	}
+	body, err := NewIndexBody(result.Mapping, typeName, index, opts.Target)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(body, "", "  ")
+	return string(data), err
+}
+
+// checkAnalysis returns a problem for every analyzer, normalizer, tokenizer,
+// token filter or character filter that is used but not declared in analysis
+// or built in.
+func checkAnalysis(mapping *Mapping, analysis *Analysis) []*FieldError {
+	if analysis == nil {
+		analysis = &Analysis{}
+	}
+	var problems []*FieldError
+	check := func(path, kind, name string, declared bool, builtin map[string]bool) {
+		if name != "" && !declared && !builtin[name] {
+			problems = append(problems, &FieldError{Path: path, Reason: fmt.Sprintf("%s %q is not declared", kind, name)})
+		}
+	}
+
+	var checkProperty func(path string, p *Property)
+	checkProperty = func(path string, p *Property) {
+		check(path, "analyzer", p.Analyzer, analysis.Analyzers[p.Analyzer] != nil, builtinAnalyzers)
+		check(path, "search analyzer", p.SearchAnalyzer, analysis.Analyzers[p.SearchAnalyzer] != nil, builtinAnalyzers)
+		check(path, "normalizer", p.Normalizer, analysis.Normalizers[p.Normalizer] != nil, builtinNormalizers)
+		for _, name := range sortedKeys(p.Fields) {
+			checkProperty(joinPath(path, name), p.Fields[name])
+		}
+		for _, name := range sortedKeys(p.Properties) {
+			checkProperty(joinPath(path, name), p.Properties[name])
+		}
+	}
+	for _, template := range mapping.DynamicTemplates {
+		if template.Mapping != nil {
+			checkProperty("dynamic_templates."+template.Name, template.Mapping)
+		}
+	}
+	for _, name := range sortedKeys(mapping.Properties) {
+		checkProperty(name, mapping.Properties[name])
+	}
+
+	checkAnalyzers := func(kind string, analyzers map[string]*Analyzer) {
+		names := make([]string, 0, len(analyzers))
+		for name := range analyzers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			a := analyzers[name]
+			path := "settings.analysis." + kind + "." + name
+			if kind == "analyzer" && (a.Type == "" || a.Type == "custom") && a.Tokenizer == "" {
+				problems = append(problems, &FieldError{Path: path, Reason: "custom analyzer needs a tokenizer"})
+			}
+			_, declared := analysis.Tokenizers[a.Tokenizer]
+			check(path, "tokenizer", a.Tokenizer, declared, builtinTokenizers)
+			for _, filter := range a.Filter {
+				_, declared := analysis.Filters[filter]
+				check(path, "filter", filter, declared, builtinTokenFilters)
+			}
+			for _, filter := range a.CharFilter {
+				_, declared := analysis.CharFilters[filter]
+				check(path, "char_filter", filter, declared, builtinCharFilters)
+			}
+		}
+	}
+	checkAnalyzers("analyzer", analysis.Analyzers)
+	checkAnalyzers("normalizer", analysis.Normalizers)
+	return problems
+}
+
+// LegacyTemplate is an index template for the _template API, which is all
+// there is before ElasticSearch 7.8.
+type LegacyTemplate struct {
+	IndexPatterns []string `json:"index_patterns,omitempty"`
+	// Template is the single pattern ElasticSearch 5 wants instead of
+	// IndexPatterns.
+	Template string `json:"template,omitempty"`
+	Order    int    `json:"order,omitempty"`
+	Version  *int   `json:"version,omitempty"`
+	*IndexBody
+}
+
+// NewLegacyTemplate returns a template applying body to indices matching
+// patterns. Templates with a higher order win over those with a lower one.
+func NewLegacyTemplate(body *IndexBody, patterns []string, order int, target Target) (*LegacyTemplate, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("elasticmapper: a template needs at least one index pattern")
+	}
+	template := &LegacyTemplate{Order: order, IndexBody: body}
+	if target.Engine == Elasticsearch && target.Major == 5 {
+		if len(patterns) > 1 {
+			return nil, fmt.Errorf("elasticmapper: %s templates only take a single index pattern", target)
+		}
+		template.Template = patterns[0]
+	} else {
+		template.IndexPatterns = patterns
+	}
+	return template, nil
+}
+
+// ComposableTemplate is an index template for the _index_template API of
+// ElasticSearch 7.8 onwards and OpenSearch, which can be composed of
+// component templates.
+type ComposableTemplate struct {
+	IndexPatterns []string               `json:"index_patterns"`
+	Template      *IndexBody             `json:"template,omitempty"`
+	ComposedOf    []string               `json:"composed_of,omitempty"`
+	Priority      *int                   `json:"priority,omitempty"`
+	Version       *int                   `json:"version,omitempty"`
+	Meta          map[string]interface{} `json:"_meta,omitempty"`
+}
+
+// ComponentTemplate is a building block for composable templates.
+type ComponentTemplate struct {
+	Template *IndexBody             `json:"template"`
+	Version  *int                   `json:"version,omitempty"`
+	Meta     map[string]interface{} `json:"_meta,omitempty"`
+}
+
+// NewComposableTemplate returns a composable template applying body to indices
+// matching patterns. The template with the highest priority wins.
+func NewComposableTemplate(body *IndexBody, patterns []string, priority int, target Target) (*ComposableTemplate, error) {
+	if !supportsComposableTemplates(target) {
+		return nil, fmt.Errorf("elasticmapper: %s doesn't support composable templates", target)
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("elasticmapper: a template needs at least one index pattern")
+	}
+	return &ComposableTemplate{IndexPatterns: patterns, Template: body, Priority: &priority}, nil
+}
+
+// NewComponentTemplate returns a component template holding body.
+func NewComponentTemplate(body *IndexBody, target Target) (*ComponentTemplate, error) {
+	if !supportsComposableTemplates(target) {
+		return nil, fmt.Errorf("elasticmapper: %s doesn't support component templates", target)
+	}
+	return &ComponentTemplate{Template: body}, nil
+}
+
+func supportsComposableTemplates(target Target) bool {
+	return target.Engine == OpenSearch || target.Engine == Elasticsearch && target.atLeast(version{7, 8})
+}
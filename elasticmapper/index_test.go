@@ -0,0 +1,62 @@
+package elasticmapper_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+type analyzed struct {
+	Title string `json:"title" elasticmapper:"text,analyzer=custom"`
+	Tag   string `json:"tag" elasticmapper:"keyword,normalizer=folded"`
+}
+
+func TestAnalysisNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		analyzer *elasticmapper.Analyzer
+		folded   *elasticmapper.Analyzer
+		want     []string
+	}{
+		{"built in", &elasticmapper.Analyzer{Tokenizer: "standard", Filter: []string{"lowercase", "asciifolding"}, CharFilter: []string{"html_strip"}},
+			&elasticmapper.Analyzer{Filter: []string{"lowercase"}}, nil},
+		{"declared", &elasticmapper.Analyzer{Tokenizer: "grams", Filter: []string{"shorten"}, CharFilter: []string{"dashes"}},
+			&elasticmapper.Analyzer{Filter: []string{"shorten"}, CharFilter: []string{"dashes"}}, nil},
+		{"unknown tokenizer", &elasticmapper.Analyzer{Tokenizer: "nope"}, &elasticmapper.Analyzer{},
+			[]string{`settings.analysis.analyzer.custom: tokenizer "nope" is not declared`}},
+		{"no tokenizer", &elasticmapper.Analyzer{Type: "custom"}, &elasticmapper.Analyzer{},
+			[]string{"settings.analysis.analyzer.custom: custom analyzer needs a tokenizer"}},
+		{"unknown filters", &elasticmapper.Analyzer{Tokenizer: "standard", Filter: []string{"lowercase", "nope"}, CharFilter: []string{"gone"}},
+			&elasticmapper.Analyzer{Filter: []string{"grams"}, CharFilter: []string{"shorten"}},
+			[]string{
+				`settings.analysis.analyzer.custom: filter "nope" is not declared`,
+				`settings.analysis.analyzer.custom: char_filter "gone" is not declared`,
+				`settings.analysis.normalizer.folded: filter "grams" is not declared`,
+				`settings.analysis.normalizer.folded: char_filter "shorten" is not declared`,
+			}},
+	}
+	for _, test := range tests {
+		index := elasticmapper.Index{Settings: elasticmapper.Settings{Analysis: &elasticmapper.Analysis{
+			Analyzers:   map[string]*elasticmapper.Analyzer{"custom": test.analyzer},
+			Normalizers: map[string]*elasticmapper.Analyzer{"folded": test.folded},
+			Tokenizers:  map[string]elasticmapper.Component{"grams": {"type": "edge_ngram"}},
+			Filters:     map[string]elasticmapper.Component{"shorten": {"type": "truncate", "length": 10}},
+			CharFilters: map[string]elasticmapper.Component{"dashes": {"type": "mapping", "mappings": []string{"- => _"}}},
+		}}}
+		_, err := elasticmapper.GetCreateIndexBody(analyzed{}, "", index, elasticmapper.Options{Target: elasticmapper.Elasticsearch8})
+		var got []string
+		var mappingErr *elasticmapper.MappingError
+		if errors.As(err, &mappingErr) {
+			for _, f := range mappingErr.Fields {
+				got = append(got, f.Path+": "+f.Reason)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
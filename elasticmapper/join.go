@@ -0,0 +1,184 @@
+package elasticmapper
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Join is the value of a join field: the relation of the document and, for
+// children, the ID of its parent. Tag the field with the relation of the
+// struct and that of its parent, if any:
+//
+//	Join elasticmapper.Join `json:"join" elasticmapper:"join,relation=answer,parent=question"`
+type Join struct {
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// MapAll generates a single mapping for several struct types sharing an index,
+// such as the parents and children of a join field. Fields with the same name
+// must map the same way in every type, except that the properties of objects
+// and the relations of join fields are merged.
+func MapAll(opts Options, inputs ...interface{}) (*Result, error) {
+	merged := &Result{Mapping: &Mapping{Properties: make(map[string]*Property)}}
+	for _, input := range inputs {
+		result, err := mapStruct(input, opts)
+		if err != nil {
+			return nil, err
+		}
+		switch dynamic := result.Mapping.Dynamic; {
+		case merged.Mapping.Dynamic == "":
+			merged.Mapping.Dynamic = dynamic
+		case dynamic != "" && dynamic != merged.Mapping.Dynamic:
+			return nil, fmt.Errorf("elasticmapper: types disagree on dynamic, %s and %s", merged.Mapping.Dynamic, dynamic)
+		}
+		if err := mergeProperties("", merged.Mapping.Properties, result.Mapping.Properties); err != nil {
+			return nil, err
+		}
+		if err := mergeTemplates(merged.Mapping, result.Mapping.DynamicTemplates); err != nil {
+			return nil, err
+		}
+		merged.Warnings = append(merged.Warnings, result.Warnings...)
+	}
+	if err := checkJoins(merged.Mapping); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeProperties adds the properties in from to those in into.
+func mergeProperties(path string, into, from map[string]*Property) error {
+	for _, name := range sortedKeys(from) {
+		property, fieldPath := from[name], joinPath(path, name)
+		existing, ok := into[name]
+		if !ok {
+			into[name] = property.Clone()
+			continue
+		}
+		existingType, newType := propertyType(existing), propertyType(property)
+		if existingType != newType {
+			return &FieldError{Path: fieldPath, Reason: fmt.Sprintf("mapped as %s in one type and as %s in another", existingType, newType)}
+		}
+		switch existingType {
+		case "object", "nested":
+			if !sameParams(existing, property) {
+				return &FieldError{Path: fieldPath, Reason: "mapped with different parameters in different types"}
+			}
+			if existing.Properties == nil && len(property.Properties) > 0 {
+				existing.Properties = make(map[string]*Property)
+			}
+			if err := mergeProperties(fieldPath, existing.Properties, property.Properties); err != nil {
+				return err
+			}
+		case "join":
+			existing.Relations = mergeRelations(existing.Relations, property.Relations)
+		default:
+			same, err := sameJSON(existing, property)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return &FieldError{Path: fieldPath, Reason: "mapped with different parameters in different types"}
+			}
+		}
+	}
+	return nil
+}
+
+// sameParams reports whether two objects have the same parameters, ignoring
+// their properties.
+func sameParams(a, b *Property) bool {
+	aParams, errA := propertyParams(a)
+	bParams, errB := propertyParams(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(aParams, bParams)
+}
+
+// sameJSON reports whether a and b turn into the same JSON.
+func sameJSON(a, b interface{}) (bool, error) {
+	var aJSON, bJSON interface{}
+	if err := normalize(a, &aJSON); err != nil {
+		return false, err
+	}
+	if err := normalize(b, &bJSON); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(aJSON, bJSON), nil
+}
+
+// mergeRelations adds the children in from to those in into.
+func mergeRelations(into, from map[string]StringList) map[string]StringList {
+	if len(from) == 0 {
+		return into
+	}
+	if into == nil {
+		into = make(map[string]StringList, len(from))
+	}
+	for parent, children := range from {
+		for _, child := range children {
+			found := false
+			for _, existing := range into[parent] {
+				found = found || existing == child
+			}
+			if !found {
+				into[parent] = append(into[parent], child)
+			}
+		}
+	}
+	return into
+}
+
+// mergeTemplates adds templates to mapping, leaving out those it has already.
+func mergeTemplates(mapping *Mapping, templates []*DynamicTemplate) error {
+	for _, template := range templates {
+		duplicate := false
+		for _, existing := range mapping.DynamicTemplates {
+			if existing.Name != template.Name {
+				continue
+			}
+			same, err := sameJSON(existing, template)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return &FieldError{Path: template.PathMatch, Reason: "mapped with different dynamic templates in different types"}
+			}
+			duplicate = true
+		}
+		if !duplicate {
+			mapping.DynamicTemplates = append(mapping.DynamicTemplates, template)
+		}
+	}
+	return nil
+}
+
+// checkJoins fails unless the mapping has at most one join field, at the
+// root, and that field has children. A parent type mapped on its own has a
+// join field without relations, which ElasticSearch rejects, and so is a join
+// field inside an object.
+func checkJoins(mapping *Mapping) error {
+	var joins []string
+	var findJoins func(path string, properties map[string]*Property)
+	findJoins = func(path string, properties map[string]*Property) {
+		for _, name := range sortedKeys(properties) {
+			property := properties[name]
+			if property.Type == "join" {
+				joins = append(joins, joinPath(path, name))
+			}
+			findJoins(joinPath(path, name), property.Properties)
+		}
+	}
+	findJoins("", mapping.Properties)
+	for _, path := range joins {
+		if strings.Contains(path, ".") {
+			return &FieldError{Path: path, Reason: "join fields must be at the root of the mapping, not inside objects"}
+		}
+	}
+	switch {
+	case len(joins) > 1:
+		return fmt.Errorf("elasticmapper: an index can only have one join field, found %s", strings.Join(joins, ", "))
+	case len(joins) == 1 && len(mapping.Property(joins[0]).Relations) == 0:
+		return &FieldError{Path: joins[0], Reason: "join field has no children; map the child types along with it with MapAll"}
+	}
+	return nil
+}
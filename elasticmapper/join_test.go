@@ -0,0 +1,90 @@
+package elasticmapper_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+)
+
+type question struct {
+	Join  elasticmapper.Join `json:"join" elasticmapper:"join,relation=question"`
+	Title string             `json:"title"`
+}
+
+type answer struct {
+	Join elasticmapper.Join `json:"join" elasticmapper:"join,relation=answer,parent=question"`
+	Body string             `json:"body"`
+}
+
+type comment struct {
+	Join elasticmapper.Join `json:"join" elasticmapper:"join,relation=comment,parent=answer"`
+}
+
+type nestedJoin struct {
+	Meta struct {
+		Join elasticmapper.Join `json:"join" elasticmapper:"join,relation=answer,parent=question"`
+	} `json:"meta"`
+}
+
+type twoJoins struct {
+	Join  elasticmapper.Join `json:"join" elasticmapper:"join,relation=answer,parent=question"`
+	Other elasticmapper.Join `json:"other" elasticmapper:"join,relation=answer,parent=question"`
+}
+
+var joinOptions = elasticmapper.Options{Target: elasticmapper.Elasticsearch8}
+
+func TestJoins(t *testing.T) {
+	result, err := elasticmapper.MapAll(joinOptions, question{}, answer{}, comment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]elasticmapper.StringList{"question": {"answer"}, "answer": {"comment"}}
+	if got := result.Mapping.Property("join").Relations; !reflect.DeepEqual(got, want) {
+		t.Errorf("relations are %v, want %v", got, want)
+	}
+
+	// A child alone knows its parent
+	result, err = elasticmapper.Map(answer{}, joinOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Mapping.Property("join").Relations; !reflect.DeepEqual(got, map[string]elasticmapper.StringList{"question": {"answer"}}) {
+		t.Errorf("relations of answer alone are %v", got)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		all  bool
+		in   []interface{}
+		path string
+		want string
+	}{
+		{"parent alone", false, []interface{}{question{}}, "join", "join field has no children"},
+		{"parent alone in MapAll", true, []interface{}{question{}}, "join", "join field has no children"},
+		{"inside an object", false, []interface{}{nestedJoin{}}, "meta.join", "must be at the root"},
+		{"inside an object in MapAll", true, []interface{}{question{}, nestedJoin{}}, "meta.join", "must be at the root"},
+		{"two join fields", true, []interface{}{question{}, twoJoins{}}, "", "can only have one join field, found join, other"},
+	}
+	for _, test := range tests {
+		var err error
+		if test.all {
+			_, err = elasticmapper.MapAll(joinOptions, test.in...)
+		} else {
+			_, err = elasticmapper.Map(test.in[0], joinOptions)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.want)
+			continue
+		}
+		if test.path == "" {
+			continue
+		}
+		if fieldErr, ok := err.(*elasticmapper.FieldError); !ok || fieldErr.Path != test.path {
+			t.Errorf("%s: got %v, want a *FieldError for %s", test.name, err, test.path)
+		}
+	}
+}
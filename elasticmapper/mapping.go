@@ -0,0 +1,238 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Mapping is the mapping of a document: what goes under "mappings" when
+// creating an index, or under "mappings.<type>" for indices with types.
+// Parameters this package doesn't know about, like _source, _meta or
+// date_detection, end up in Extra, as they do for a Property.
+type Mapping struct {
+	Dynamic          DynamicMode          `json:"dynamic,omitempty"`
+	DynamicTemplates []*DynamicTemplate   `json:"dynamic_templates,omitempty"`
+	Properties       map[string]*Property `json:"properties,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// mapping is Mapping without its methods, like property.
+type mapping Mapping
+
+// MarshalJSON writes m including the parameters in m.Extra.
+func (m *Mapping) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*mapping)(m), m.Extra)
+}
+
+// UnmarshalJSON reads m, keeping parameters without a field of their own in
+// m.Extra.
+func (m *Mapping) UnmarshalJSON(data []byte) error {
+	*m = Mapping{}
+	extra, err := unmarshalWithExtra(data, (*mapping)(m))
+	m.Extra = extra
+	return err
+}
+
+// Property is the mapping of a single field. Parameters this package doesn't
+// know about end up in Extra, so a mapping read from ElasticSearch can be
+// written back without losing anything.
+type Property struct {
+	Type string `json:"type,omitempty"`
+
+	// Properties are the fields of an object or nested property.
+	Properties map[string]*Property `json:"properties,omitempty"`
+	// Enabled false keeps an object in _source without indexing it.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Dynamic is what happens to fields of an object that aren't in its
+	// properties.
+	Dynamic DynamicMode `json:"dynamic,omitempty"`
+	// IncludeInParent and IncludeInRoot copy the fields of nested objects
+	// into their parent or the root document, so they can be queried without
+	// a nested query too.
+	IncludeInParent *bool `json:"include_in_parent,omitempty"`
+	IncludeInRoot   *bool `json:"include_in_root,omitempty"`
+
+	Analyzer       string               `json:"analyzer,omitempty"`
+	SearchAnalyzer string               `json:"search_analyzer,omitempty"`
+	Normalizer     string               `json:"normalizer,omitempty"`
+	Fields         map[string]*Property `json:"fields,omitempty"`
+	Index          *bool                `json:"index,omitempty"`
+	DocValues      *bool                `json:"doc_values,omitempty"`
+	CopyTo         StringList           `json:"copy_to,omitempty"`
+	Format         string               `json:"format,omitempty"`
+	NullValue      interface{}          `json:"null_value,omitempty"`
+	IgnoreAbove    *int                 `json:"ignore_above,omitempty"`
+	ScalingFactor  *float64             `json:"scaling_factor,omitempty"`
+	MaxShingleSize *int                 `json:"max_shingle_size,omitempty"`
+	Contexts       []*CompletionContext `json:"contexts,omitempty"`
+
+	// Dims is the number of dimensions of a dense_vector, Dimension that of
+	// an OpenSearch knn_vector.
+	Dims       *int   `json:"dims,omitempty"`
+	Dimension  *int   `json:"dimension,omitempty"`
+	Similarity string `json:"similarity,omitempty"`
+
+	// Relations of a join field map each parent to its children.
+	Relations map[string]StringList `json:"relations,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+
+	// Multiple is set by Map on fields holding a slice or array of values.
+	// ElasticSearch doesn't care, but other backends do.
+	Multiple bool `json:"-"`
+}
+
+// property is Property without its methods, so it can be (un)marshaled with
+// the default encoding/json behavior.
+type property Property
+
+// MarshalJSON writes p including the parameters in p.Extra.
+func (p *Property) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra((*property)(p), p.Extra)
+}
+
+// UnmarshalJSON reads p, keeping parameters without a field of their own in
+// p.Extra.
+func (p *Property) UnmarshalJSON(data []byte) error {
+	*p = Property{}
+	extra, err := unmarshalWithExtra(data, (*property)(p))
+	p.Extra = extra
+	return err
+}
+
+// marshalWithExtra marshals v, a struct without a MarshalJSON method of its
+// own, and adds the keys in extra next to its fields.
+func marshalWithExtra(v interface{}, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := params[key]; ok {
+			return nil, fmt.Errorf("elasticmapper: %q is set both as a field and in Extra", key)
+		}
+		params[key] = value
+	}
+	return json.Marshal(params)
+}
+
+// unmarshalWithExtra unmarshals data into v, a pointer to a struct without an
+// UnmarshalJSON method of its own, and returns the keys v has no field for.
+func unmarshalWithExtra(data []byte, v interface{}) (map[string]interface{}, error) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	for _, f := range typeFields(indirect(reflect.TypeOf(v)), false) {
+		delete(params, f.name)
+	}
+	var extra map[string]interface{}
+	for key, raw := range params {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra[key] = value
+	}
+	return extra, nil
+}
+
+// CompletionContext is a context of a completion field, which suggestions can
+// be filtered or boosted by.
+type CompletionContext struct {
+	Name string `json:"name"`
+	// Type is either category or geo.
+	Type string `json:"type"`
+	// Path is the field the context is taken from, if it's not given along
+	// with the suggestion.
+	Path      string      `json:"path,omitempty"`
+	Precision interface{} `json:"precision,omitempty"`
+}
+
+// StringList is a list of strings that ElasticSearch lets you write as a
+// single string when there's only one.
+type StringList []string
+
+// MarshalJSON writes a list with one element as just that element.
+func (l StringList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// UnmarshalJSON reads either a string or a list of strings.
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// DynamicTemplate maps fields that aren't in the mapping up front but match
+// the template once they show up in a document.
+type DynamicTemplate struct {
+	Name string `json:"-"`
+
+	Match            string    `json:"match,omitempty"`
+	Unmatch          string    `json:"unmatch,omitempty"`
+	PathMatch        string    `json:"path_match,omitempty"`
+	PathUnmatch      string    `json:"path_unmatch,omitempty"`
+	MatchMappingType string    `json:"match_mapping_type,omitempty"`
+	Mapping          *Property `json:"mapping"`
+}
+
+// dynamicTemplate is DynamicTemplate without its methods.
+type dynamicTemplate DynamicTemplate
+
+// MarshalJSON writes t the way ElasticSearch expects it, as an object with the
+// name of the template as its only key.
+func (t *DynamicTemplate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]*dynamicTemplate{t.Name: (*dynamicTemplate)(t)})
+}
+
+// UnmarshalJSON reads a template written as an object keyed by its name.
+func (t *DynamicTemplate) UnmarshalJSON(data []byte) error {
+	var named map[string]*dynamicTemplate
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	if len(named) != 1 {
+		return errors.New("elasticmapper: a dynamic template must have exactly one name")
+	}
+	for name, template := range named {
+		*t = DynamicTemplate(*template)
+		t.Name = name
+	}
+	return nil
+}
+
+// Property returns the property at the given dotted path, such as "a.b" for
+// the property b of object a, or nil if there's no such property.
+func (m *Mapping) Property(path string) *Property {
+	properties := m.Properties
+	var p *Property
+	for _, name := range strings.Split(path, ".") {
+		if p = properties[name]; p == nil {
+			return nil
+		}
+		properties = p.Properties
+	}
+	return p
+}
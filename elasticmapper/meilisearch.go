@@ -0,0 +1,89 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MeilisearchEmitter writes a mapping as a Meilisearch settings document,
+// sorting the fields into searchable, filterable and sortable attributes:
+//
+//   - text fields are searchable
+//   - keyword fields and text fields with a keyword multi-field are
+//     filterable and sortable
+//   - numbers and dates are filterable and sortable, booleans filterable
+//   - a geo_point named _geo is filterable and sortable
+//
+// Fields with index=false are left out. Meilisearch ranks matches in
+// searchable attributes by their order, which is alphabetical here; reorder
+// them by hand if it matters.
+type MeilisearchEmitter struct{}
+
+type meilisearchSettings struct {
+	SearchableAttributes []string `json:"searchableAttributes,omitempty"`
+	FilterableAttributes []string `json:"filterableAttributes,omitempty"`
+	SortableAttributes   []string `json:"sortableAttributes,omitempty"`
+}
+
+// Emit implements Emitter.
+func (e *MeilisearchEmitter) Emit(mapping *Mapping) ([]byte, error) {
+	settings := &meilisearchSettings{}
+	add := func(path string, p *Property) {
+		if p.Index != nil && !*p.Index {
+			return
+		}
+		searchable, filterable, sortable := meilisearchAttribute(path, p)
+		for _, multiField := range p.Fields {
+			_, f, s := meilisearchAttribute(path, multiField)
+			filterable, sortable = filterable || f, sortable || s
+		}
+		if searchable {
+			settings.SearchableAttributes = append(settings.SearchableAttributes, path)
+		}
+		if filterable {
+			settings.FilterableAttributes = append(settings.FilterableAttributes, path)
+		}
+		if sortable {
+			settings.SortableAttributes = append(settings.SortableAttributes, path)
+		}
+	}
+	var walk func(path string, properties map[string]*Property)
+	walk = func(path string, properties map[string]*Property) {
+		for _, name := range sortedKeys(properties) {
+			p, fieldPath := properties[name], joinPath(path, name)
+			if t := propertyType(p); t == "object" || t == "nested" {
+				if p.Enabled == nil || *p.Enabled {
+					walk(fieldPath, p.Properties)
+				}
+				continue
+			}
+			add(fieldPath, p)
+		}
+	}
+	walk("", mapping.Properties)
+	// Maps: Meilisearch takes an object as an attribute for all its fields
+	for _, template := range mapping.DynamicTemplates {
+		if strings.HasSuffix(template.PathMatch, ".*") && strings.Count(template.PathMatch, "*") == 1 {
+			add(strings.TrimSuffix(template.PathMatch, ".*"), template.Mapping)
+		}
+	}
+	return json.MarshalIndent(settings, "", "  ")
+}
+
+// meilisearchAttribute says what a field of type p can be used for.
+func meilisearchAttribute(path string, p *Property) (searchable, filterable, sortable bool) {
+	switch p.Type {
+	case "text", "match_only_text", "search_as_you_type", "completion":
+		return true, false, false
+	case "keyword", "wildcard", "ip":
+		return false, true, true
+	case "long", "integer", "short", "byte", "unsigned_long", "double", "float", "half_float", "scaled_float", "date", "date_nanos":
+		return false, true, true
+	case "boolean":
+		return false, true, false
+	case "geo_point":
+		isGeo := path == "_geo"
+		return false, isGeo, isGeo
+	}
+	return false, false, false
+}
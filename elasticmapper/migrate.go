@@ -0,0 +1,281 @@
+package elasticmapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Migration describes a move of the documents behind an alias to a new index
+// with a new mapping, for changes that can't be made to the live index.
+type Migration struct {
+	// Alias is the name clients use for the index. It's moved from From to
+	// To once To holds all the documents.
+	Alias string
+	// From is the index behind Alias now, or empty if there's none yet.
+	From string
+	// To is the index to create, typically Alias with a version suffix like
+	// "things_v2".
+	To string
+
+	// Options and TypeName are used for mapping both versions of the
+	// struct, and Index for the settings of the new index.
+	Options  Options
+	TypeName string
+	Index    Index
+
+	// Renames maps the dotted paths of fields in the old struct to their
+	// paths in the new one. They're moved by a painless script during the
+	// reindex. Fields in arrays of objects can't be renamed this way.
+	Renames map[string]string
+	// Script is more painless to run on each document during the reindex,
+	// after the renames. It can change ctx._source.
+	Script string
+
+	// KeepFrom leaves the old index around after the switch instead of
+	// deleting it, to switch back if something's wrong.
+	KeepFrom bool
+}
+
+// Request is a single call to the REST API.
+type Request struct {
+	Method string
+	// Path is the path of the request, with any query string.
+	Path string
+	// Body is marshaled as JSON, unless it's nil.
+	Body interface{}
+	// Description says what the request is for.
+	Description string
+}
+
+func (r *Request) String() string {
+	if r.Body == nil {
+		return r.Method + " " + r.Path
+	}
+	// Scripts are easier to read without < and > escaped
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r.Body); err != nil {
+		return fmt.Sprintf("%s %s\n%v", r.Method, r.Path, err)
+	}
+	return fmt.Sprintf("%s %s\n%s", r.Method, r.Path, bytes.TrimSuffix(body.Bytes(), []byte("\n")))
+}
+
+// MigrationPlan is the list of requests that carries out a Migration.
+type MigrationPlan struct {
+	Requests []*Request
+	// Diff lists the changes from the old mapping to the new one, which is
+	// nil if there's no old index.
+	Diff *MappingDiff
+}
+
+// PlanMigration returns the requests to move the documents of old, the
+// current version of a struct, from migration.From to a new index for new,
+// its next version, without clients of migration.Alias noticing:
+//
+//  1. create migration.To with the mapping of new
+//  2. reindex the documents into it, renaming fields as it goes
+//  3. refresh it, so the documents are searchable
+//  4. point the alias at it instead of the old index, in one step
+//  5. delete the old index
+//
+// Documents written to the alias while the reindex runs end up in the old
+// index only, so pause writes or replay them afterwards. Without a From, the
+// plan only creates the index and adds the alias, and old may be nil.
+func PlanMigration(old, new interface{}, migration Migration) (*MigrationPlan, error) {
+	if migration.Alias == "" || migration.To == "" {
+		return nil, errors.New("elasticmapper: a migration needs an alias and an index to migrate to")
+	}
+	if migration.From == migration.To || migration.Alias == migration.From || migration.Alias == migration.To {
+		return nil, errors.New("elasticmapper: the alias and the indices of a migration must all have different names")
+	}
+	if migration.From == "" && (len(migration.Renames) > 0 || migration.Script != "") {
+		return nil, errors.New("elasticmapper: renames and scripts need an index to migrate from")
+	}
+	if _, ok := migration.Index.Aliases[migration.Alias]; ok {
+		return nil, fmt.Errorf("elasticmapper: alias %s is added by the migration, so it can't be in Index.Aliases", migration.Alias)
+	}
+
+	newResult, err := Map(new, migration.Options)
+	if err != nil {
+		return nil, err
+	}
+	body, err := NewIndexBody(newResult.Mapping, migration.TypeName, migration.Index, migration.Options.Target)
+	if err != nil {
+		return nil, err
+	}
+	plan := &MigrationPlan{}
+	plan.add("PUT", "/"+migration.To, body, "create the new index")
+
+	var renames []string
+	for from := range migration.Renames {
+		renames = append(renames, from)
+	}
+	sort.Strings(renames)
+	for _, from := range renames {
+		if newResult.Mapping.Property(migration.Renames[from]) == nil {
+			return nil, &FieldError{Path: migration.Renames[from], Reason: "renamed field isn't in the new mapping"}
+		}
+	}
+	if migration.From != "" {
+		if old != nil {
+			oldResult, err := Map(old, migration.Options)
+			if err != nil {
+				return nil, err
+			}
+			for _, from := range renames {
+				if oldResult.Mapping.Property(from) == nil {
+					return nil, &FieldError{Path: from, Reason: "renamed field isn't in the old mapping"}
+				}
+			}
+			if plan.Diff, err = DiffMappings(oldResult.Mapping, newResult.Mapping); err != nil {
+				return nil, err
+			}
+		}
+		reindex := map[string]interface{}{
+			"source": map[string]interface{}{"index": migration.From},
+			"dest":   map[string]interface{}{"index": migration.To},
+		}
+		if script := reindexScript(renames, migration.Renames, migration.Script); script != "" {
+			reindex["script"] = map[string]interface{}{"lang": "painless", "source": script}
+		}
+		plan.add("POST", "/_reindex?wait_for_completion=true", reindex, "copy the documents into the new index")
+		plan.add("POST", "/"+migration.To+"/_refresh", nil, "make the copied documents searchable")
+	}
+
+	var actions []interface{}
+	if migration.From != "" {
+		actions = append(actions, map[string]interface{}{"remove": map[string]string{"index": migration.From, "alias": migration.Alias}})
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]string{"index": migration.To, "alias": migration.Alias}})
+	plan.add("POST", "/_aliases", map[string]interface{}{"actions": actions}, "point the alias at the new index")
+
+	if migration.From != "" && !migration.KeepFrom {
+		plan.add("DELETE", "/"+migration.From, nil, "delete the old index")
+	}
+	return plan, nil
+}
+
+func (p *MigrationPlan) add(method, path string, body interface{}, description string) {
+	p.Requests = append(p.Requests, &Request{Method: method, Path: path, Body: body, Description: description})
+}
+
+// moveFunction is a painless function moving the value at one path of a
+// document to another, creating objects on the way as needed.
+const moveFunction = `void move(Map source, List from, List to) {
+  Map m = source;
+  for (int i = 0; i < from.size() - 1; i++) {
+    def next = m.get(from.get(i));
+    if (!(next instanceof Map)) {
+      return;
+    }
+    m = (Map) next;
+  }
+  if (!m.containsKey(from.get(from.size() - 1))) {
+    return;
+  }
+  def value = m.remove(from.get(from.size() - 1));
+  m = source;
+  for (int i = 0; i < to.size() - 1; i++) {
+    if (!(m.get(to.get(i)) instanceof Map)) {
+      m.put(to.get(i), new HashMap());
+    }
+    m = (Map) m.get(to.get(i));
+  }
+  m.put(to.get(to.size() - 1), value);
+}
+`
+
+// reindexScript returns the painless source doing the renames, in the order
+// of paths, and then running script.
+func reindexScript(paths []string, renames map[string]string, script string) string {
+	var source strings.Builder
+	if len(paths) > 0 {
+		source.WriteString(moveFunction)
+	}
+	list := func(path string) string {
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			parts[i] = "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(part) + "'"
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	for _, from := range paths {
+		fmt.Fprintf(&source, "move(ctx._source, %s, %s);\n", list(from), list(renames[from]))
+	}
+	source.WriteString(script)
+	return source.String()
+}
+
+// RequestError is returned by MigrationPlan.Execute for a request that
+// failed.
+type RequestError struct {
+	Request *Request
+	// Status is the HTTP status of the response.
+	Status int
+	// Response is the body of the response.
+	Response []byte
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("elasticmapper: %s %s failed with status %d: %s", e.Request.Method, e.Request.Path, e.Status, bytes.TrimSpace(e.Response))
+}
+
+// Execute sends the requests of p in order to the cluster at baseURL, like
+// "http://localhost:9200", stopping at the first one that fails with a
+// *RequestError. A reindex that fails for some documents counts as failed.
+// It uses http.DefaultClient if client is nil.
+func (p *MigrationPlan) Execute(client *http.Client, baseURL string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	for _, r := range p.Requests {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = json.Marshal(r.Body); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest(r.Method, baseURL+r.Path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		response, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 {
+			return &RequestError{Request: r, Status: resp.StatusCode, Response: response}
+		}
+		if strings.HasPrefix(r.Path, "/_reindex") {
+			var result struct {
+				TimedOut bool              `json:"timed_out"`
+				Failures []json.RawMessage `json:"failures"`
+			}
+			if err := json.Unmarshal(response, &result); err != nil {
+				return err
+			}
+			if result.TimedOut || len(result.Failures) > 0 {
+				return &RequestError{Request: r, Status: resp.StatusCode, Response: response}
+			}
+		}
+	}
+	return nil
+}
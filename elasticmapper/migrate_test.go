@@ -0,0 +1,161 @@
+package elasticmapper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/elasticmapper"
+	"github.com/wingedrhino/golang-snippets/elasticmapper/estest"
+)
+
+type personV1 struct {
+	ID   string `json:"-" elasticmapper:"_id"`
+	Name string `json:"name"`
+	Mail string `json:"mail"`
+}
+
+type personV2 struct {
+	Name    string `json:"name"`
+	Contact struct {
+		Email string `json:"email"`
+	} `json:"contact"`
+}
+
+var migrationOptions = elasticmapper.Options{Target: elasticmapper.Elasticsearch8, Dynamic: elasticmapper.DynamicStrict}
+
+// startPeople creates people_v1 behind the people alias on server and loads
+// two documents into it.
+func startPeople(t *testing.T, server *estest.Server) {
+	t.Helper()
+	plan, err := elasticmapper.PlanMigration(nil, personV1{}, elasticmapper.Migration{Alias: "people", To: "people_v1", Options: migrationOptions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Execute(nil, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	bulk := elasticmapper.NewBulkEncoder(elasticmapper.BulkOptions{Index: "people"}, func(batch []byte, actions int) error {
+		resp, err := http.Post(server.URL+"/_bulk", "application/x-ndjson", bytes.NewReader(batch))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		if result.Errors {
+			return errors.New("bulk request had errors")
+		}
+		return nil
+	})
+	for _, person := range []personV1{{"1", "Ada", "ada@example.com"}, {"2", "Alan", "alan@example.com"}} {
+		if err := bulk.Index(person); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bulk.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationWithRenames(t *testing.T) {
+	server := estest.NewServer()
+	defer server.Close()
+	startPeople(t, server)
+	before := len(server.Requests())
+
+	plan, err := elasticmapper.PlanMigration(personV1{}, personV2{}, elasticmapper.Migration{
+		Alias:    "people",
+		From:     "people_v1",
+		To:       "people_v2",
+		Options:  migrationOptions,
+		Renames:  map[string]string{"mail": "contact.email"},
+		KeepFrom: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var planned []string
+	for _, r := range plan.Requests {
+		planned = append(planned, r.Method+" "+r.Path)
+	}
+	want := []string{
+		"PUT /people_v2",
+		"POST /_reindex?wait_for_completion=true",
+		"POST /people_v2/_refresh",
+		"POST /_aliases",
+	}
+	if !reflect.DeepEqual(planned, want) {
+		t.Fatalf("planned requests are %q, want %q", planned, want)
+	}
+
+	if err := plan.Execute(nil, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	for _, r := range server.Requests()[before:] {
+		if r.Status != http.StatusOK {
+			t.Errorf("%s %s got status %d", r.Method, r.Path, r.Status)
+		}
+		sent = append(sent, r.Method+" "+r.Path)
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent requests are %q, want %q", sent, want)
+	}
+
+	if aliased := server.Aliases("people"); !reflect.DeepEqual(aliased, []string{"people_v2"}) {
+		t.Errorf("people points at %v, want [people_v2]", aliased)
+	}
+	if kept := server.Documents("people_v1"); len(kept) != 2 {
+		t.Errorf("people_v1 holds %d documents, want it kept with 2", len(kept))
+	}
+	docs := server.Documents("people_v2")
+	for id, email := range map[string]string{"1": "ada@example.com", "2": "alan@example.com"} {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docs[id], &doc); err != nil {
+			t.Fatalf("document %s: %v", id, err)
+		}
+		if _, ok := doc["mail"]; ok {
+			t.Errorf("document %s still has mail: %s", id, docs[id])
+		}
+		if got := fmt.Sprint(doc["contact"]); got != fmt.Sprint(map[string]interface{}{"email": email}) {
+			t.Errorf("document %s has contact %s, want email %s", id, got, email)
+		}
+	}
+}
+
+func TestMigrationWithoutRenamesFails(t *testing.T) {
+	server := estest.NewServer()
+	defer server.Close()
+	startPeople(t, server)
+
+	// Without the rename the old documents don't fit the strict new mapping
+	plan, err := elasticmapper.PlanMigration(personV1{}, personV2{}, elasticmapper.Migration{
+		Alias:   "people",
+		From:    "people_v1",
+		To:      "people_v2",
+		Options: migrationOptions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = plan.Execute(nil, server.URL)
+	requestErr, ok := err.(*elasticmapper.RequestError)
+	if !ok {
+		t.Fatalf("Execute returned %v, want a *RequestError", err)
+	}
+	if requestErr.Request.Path != "/_reindex?wait_for_completion=true" {
+		t.Errorf("%s failed, want the reindex to", requestErr.Request.Path)
+	}
+	if aliased := server.Aliases("people"); !reflect.DeepEqual(aliased, []string{"people_v1"}) {
+		t.Errorf("people points at %v, want it left at [people_v1]", aliased)
+	}
+}
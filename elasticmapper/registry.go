@@ -0,0 +1,166 @@
+package elasticmapper
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Mapper is implemented by types that know their own mapping. The method is
+// called on the zero value of the type, or on a pointer to it for pointer
+// receivers, so it must not depend on the value.
+type Mapper interface {
+	ElasticProperty() *Property
+}
+
+var mapperType = reflect.TypeOf((*Mapper)(nil)).Elem()
+
+// Registry holds the mappings of types that can't be worked out from their
+// structure, such as net.IP which is a []byte but is indexed as an ip.
+type Registry struct {
+	mu    sync.RWMutex
+	types map[reflect.Type]*Property
+}
+
+// NewRegistry returns a registry that knows about time.Time, time.Duration,
+// net.IP, json.RawMessage, json.Number, GeoPoint and Join.
+func NewRegistry() *Registry {
+	r := &Registry{types: make(map[reflect.Type]*Property)}
+	disabled := false
+	r.Register(reflect.TypeOf(time.Time{}), &Property{Type: "date"})
+	// encoding/json writes durations as a number of nanoseconds
+	r.Register(reflect.TypeOf(time.Duration(0)), &Property{Type: "long"})
+	r.Register(reflect.TypeOf(net.IP{}), &Property{Type: "ip"})
+	// Raw JSON could be anything, so keep it in _source but don't index it
+	r.Register(reflect.TypeOf(json.RawMessage{}), &Property{Type: "object", Enabled: &disabled})
+	r.Register(reflect.TypeOf(json.Number("")), &Property{Type: "double"})
+	r.Register(reflect.TypeOf(GeoPoint{}), &Property{Type: "geo_point"})
+	r.Register(reflect.TypeOf(Join{}), &Property{Type: "join"})
+	return r
+}
+
+// GeoPoint is a location, written the way geo_point fields expect it.
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Register makes fields of type t map to property. Options from the tag of a
+// field are applied on top of it.
+func (r *Registry) Register(t reflect.Type, property *Property) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[t] = property
+}
+
+// lookup returns a copy of the property registered for t.
+func (r *Registry) lookup(t reflect.Type) (*Property, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	property, ok := r.types[t]
+	if !ok {
+		return nil, false
+	}
+	return property.Clone(), true
+}
+
+// DefaultRegistry is the registry used unless Options.Registry says otherwise.
+var DefaultRegistry = NewRegistry()
+
+// Register adds a mapping for t to DefaultRegistry.
+func Register(t reflect.Type, property *Property) {
+	DefaultRegistry.Register(t, property)
+}
+
+// Clone returns a copy of p that can be changed without touching p. Values in
+// p.Extra and p.NullValue are shared.
+func (p *Property) Clone() *Property {
+	clone := *p
+	clone.Properties = cloneProperties(p.Properties)
+	clone.Fields = cloneProperties(p.Fields)
+	if p.CopyTo != nil {
+		clone.CopyTo = append(StringList(nil), p.CopyTo...)
+	}
+	if p.Extra != nil {
+		clone.Extra = make(map[string]interface{}, len(p.Extra))
+		for k, v := range p.Extra {
+			clone.Extra[k] = v
+		}
+	}
+	for _, ptr := range []**bool{&clone.Index, &clone.DocValues, &clone.Enabled, &clone.IncludeInParent, &clone.IncludeInRoot} {
+		if *ptr != nil {
+			b := **ptr
+			*ptr = &b
+		}
+	}
+	if p.ScalingFactor != nil {
+		f := *p.ScalingFactor
+		clone.ScalingFactor = &f
+	}
+	for _, ptr := range []**int{&clone.IgnoreAbove, &clone.MaxShingleSize, &clone.Dims, &clone.Dimension} {
+		if *ptr != nil {
+			n := **ptr
+			*ptr = &n
+		}
+	}
+	if p.Relations != nil {
+		clone.Relations = make(map[string]StringList, len(p.Relations))
+		for parent, children := range p.Relations {
+			clone.Relations[parent] = append(StringList(nil), children...)
+		}
+	}
+	if p.Contexts != nil {
+		clone.Contexts = make([]*CompletionContext, len(p.Contexts))
+		for i, c := range p.Contexts {
+			context := *c
+			clone.Contexts[i] = &context
+		}
+	}
+	return &clone
+}
+
+func cloneProperties(properties map[string]*Property) map[string]*Property {
+	if properties == nil {
+		return nil
+	}
+	clone := make(map[string]*Property, len(properties))
+	for name, p := range properties {
+		clone[name] = p.Clone()
+	}
+	return clone
+}
+
+// customProperty returns the property for types that don't map by their
+// structure: those in the registry, those implementing Mapper, and those
+// implementing encoding.TextMarshaler, which are written as strings. A Mapper
+// returning nil gives a nil property, meaning the field can't be mapped.
+func (m *mapper) customProperty(t reflect.Type) (*Property, bool) {
+	registry := m.opts.Registry
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	if property, ok := registry.lookup(t); ok {
+		return property, true
+	}
+	if t.Kind() != reflect.Interface {
+		var custom Mapper
+		if t.Implements(mapperType) {
+			custom = reflect.Zero(t).Interface().(Mapper)
+		} else if reflect.PtrTo(t).Implements(mapperType) {
+			custom = reflect.New(t).Interface().(Mapper)
+		}
+		if custom != nil {
+			// The property may well be shared, and tag options change it
+			if property := custom.ElasticProperty(); property != nil {
+				return property.Clone(), true
+			}
+			return nil, true
+		}
+		if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+			return &Property{Type: "keyword"}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,155 @@
+package elasticmapper
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SolrEmitter writes a mapping as the fields of a Solr schema.xml, using the
+// field types of Solr's _default configset. Objects are flattened into dotted
+// field names, maps become dynamic fields, and copy_to and multi-fields
+// become copy fields. Fields Solr has no type for are left out with a comment
+// saying so.
+type SolrEmitter struct {
+	// TextTypes maps analyzers of text fields to Solr field types. Text
+	// fields with other analyzers are text_general.
+	TextTypes map[string]string
+}
+
+// defaultSolrTextTypes are the text field types of the _default configset
+// that match an ElasticSearch analyzer.
+var defaultSolrTextTypes = map[string]string{
+	"english":    "text_en",
+	"german":     "text_de",
+	"french":     "text_fr",
+	"spanish":    "text_es",
+	"whitespace": "text_ws",
+}
+
+// solrTypes are the Solr field types for other ElasticSearch types.
+var solrTypes = map[string]string{
+	"keyword":            "string",
+	"wildcard":           "string",
+	"ip":                 "string",
+	"match_only_text":    "text_general",
+	"search_as_you_type": "text_general",
+	"completion":         "text_general",
+	"long":               "plong",
+	"unsigned_long":      "plong",
+	"integer":            "pint",
+	"short":              "pint",
+	"byte":               "pint",
+	"double":             "pdouble",
+	"scaled_float":       "pdouble",
+	"float":              "pfloat",
+	"half_float":         "pfloat",
+	"boolean":            "boolean",
+	"date":               "pdate",
+	"date_nanos":         "pdate",
+	"binary":             "binary",
+	"geo_point":          "location",
+}
+
+// solrWriter writes the elements of a Solr schema one per line.
+type solrWriter struct {
+	buf        bytes.Buffer
+	copyFields [][2]string
+}
+
+func (w *solrWriter) comment(format string, args ...interface{}) {
+	fmt.Fprintf(&w.buf, "  <!-- %s -->\n", strings.ReplaceAll(fmt.Sprintf(format, args...), "--", "- -"))
+}
+
+// element writes an element with attributes given as name and value pairs.
+func (w *solrWriter) element(name string, attrs ...string) {
+	w.buf.WriteString("  <" + name)
+	for i := 0; i < len(attrs); i += 2 {
+		w.buf.WriteString(" " + attrs[i] + `="`)
+		xml.EscapeText(&w.buf, []byte(attrs[i+1]))
+		w.buf.WriteString(`"`)
+	}
+	w.buf.WriteString("/>\n")
+}
+
+// Emit implements Emitter.
+func (e *SolrEmitter) Emit(mapping *Mapping) ([]byte, error) {
+	w := &solrWriter{}
+	w.buf.WriteString("<fields>\n")
+	e.fields(w, "", mapping.Properties, false)
+	for _, template := range mapping.DynamicTemplates {
+		// Only templates for everything under a path have a dynamic
+		// field; Solr only takes a * at either end of the name
+		if template.PathMatch == "" || strings.Count(template.PathMatch, "*") != 1 || !strings.HasSuffix(template.PathMatch, "*") ||
+			template.Match != "" || template.MatchMappingType != "" {
+			w.comment("dynamic template %s has no equivalent", template.Name)
+			continue
+		}
+		e.field(w, "dynamicField", template.PathMatch, template.Mapping, false)
+	}
+	for _, copyField := range w.copyFields {
+		w.element("copyField", "source", copyField[0], "dest", copyField[1])
+	}
+	w.buf.WriteString("</fields>\n")
+	return w.buf.Bytes(), nil
+}
+
+// fields writes a field for every property, flattening objects into dotted
+// names. Everything in a multiple object is multiple too.
+func (e *SolrEmitter) fields(w *solrWriter, path string, properties map[string]*Property, multiple bool) {
+	for _, name := range sortedKeys(properties) {
+		p, fieldPath := properties[name], joinPath(path, name)
+		switch propertyType(p) {
+		case "object", "nested":
+			if p.Enabled != nil && !*p.Enabled {
+				w.comment("%s isn't indexed, and Solr has no _source to keep it in", fieldPath)
+				continue
+			}
+			e.fields(w, fieldPath, p.Properties, multiple || p.Multiple)
+			continue
+		}
+		e.field(w, "field", fieldPath, p, multiple)
+		for _, dest := range p.CopyTo {
+			w.copyFields = append(w.copyFields, [2]string{fieldPath, dest})
+		}
+		for _, fieldName := range sortedKeys(p.Fields) {
+			multiField := joinPath(fieldPath, fieldName)
+			e.field(w, "field", multiField, p.Fields[fieldName], multiple || p.Multiple)
+			w.copyFields = append(w.copyFields, [2]string{fieldPath, multiField})
+		}
+	}
+}
+
+// field writes a field or dynamic field element for p, or a comment if Solr
+// has no type for it.
+func (e *SolrEmitter) field(w *solrWriter, element, name string, p *Property, multiple bool) {
+	solrType := solrTypes[p.Type]
+	if p.Type == "text" {
+		solrType = "text_general"
+		textTypes := e.TextTypes
+		if textTypes == nil {
+			textTypes = defaultSolrTextTypes
+		}
+		if t, ok := textTypes[p.Analyzer]; ok {
+			solrType = t
+		}
+	}
+	if solrType == "" {
+		w.comment("%s: %s has no equivalent", name, p.Type)
+		return
+	}
+	attrs := []string{"name", name, "type", solrType}
+	if p.Index != nil {
+		attrs = append(attrs, "indexed", strconv.FormatBool(*p.Index))
+	}
+	attrs = append(attrs, "stored", "true")
+	if p.DocValues != nil {
+		attrs = append(attrs, "docValues", strconv.FormatBool(*p.DocValues))
+	}
+	if multiple || p.Multiple {
+		attrs = append(attrs, "multiValued", "true")
+	}
+	w.element(element, attrs...)
+}
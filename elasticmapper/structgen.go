@@ -0,0 +1,495 @@
+package elasticmapper
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateStructs writes Go source for a package declaring a struct named
+// typeName whose mapping is mapping, along with a struct for every object and
+// nested property in it. Fields get json tags with the property names and
+// elasticmapper tags wherever the Go type alone wouldn't map the same way, so
+// that mapping the struct reproduces mapping.
+//
+// Parameters that can't be written in a tag, like those of multi-fields or
+// ones this package doesn't know, are listed in a comment on the field.
+func GenerateStructs(mapping *Mapping, packageName, typeName string) ([]byte, error) {
+	if !isIdentifier(packageName) {
+		return nil, fmt.Errorf("elasticmapper: bad package name %q", packageName)
+	}
+	if !isIdentifier(typeName) {
+		return nil, fmt.Errorf("elasticmapper: bad type name %q", typeName)
+	}
+	g := &structGenerator{
+		imports:   make(map[string]bool),
+		typeNames: make(map[string]bool),
+		templates: make(map[string]*DynamicTemplate),
+	}
+	var unused []string
+	for _, template := range mapping.DynamicTemplates {
+		// Only templates matching everything under a path, like those made
+		// for maps, can turn back into Go types
+		if template.PathMatch == "" || template.Match != "" || template.Unmatch != "" ||
+			template.PathUnmatch != "" || template.MatchMappingType != "" || !strings.Contains(template.PathMatch, ".*") {
+			unused = append(unused, template.Name)
+			continue
+		}
+		g.templates[template.PathMatch] = template
+	}
+	var doc []string
+	if len(unused) > 0 {
+		doc = append(doc, "Dynamic templates that aren't reproduced: "+strings.Join(unused, ", "))
+	}
+	g.typeNames[typeName] = true
+	if mapping.Dynamic != "" {
+		g.imports["github.com/wingedrhino/golang-snippets/elasticmapper"] = true
+	}
+	g.rootDynamic = mapping.Dynamic
+	if err := g.writeStruct(typeName, "", mapping.Properties, doc); err != nil {
+		return nil, err
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// This file was generated by elasticmapper from a mapping.\n\npackage %s\n\n", packageName)
+	if len(g.imports) > 0 {
+		// The standard library first, then everything else
+		var std, other []string
+		for path := range g.imports {
+			if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+				other = append(other, strconv.Quote(path))
+			} else {
+				std = append(std, strconv.Quote(path))
+			}
+		}
+		sort.Strings(std)
+		sort.Strings(other)
+		groups := std
+		if len(std) > 0 && len(other) > 0 {
+			groups = append(groups, "")
+		}
+		groups = append(groups, other...)
+		fmt.Fprintf(&src, "import (\n%s\n)\n\n", strings.Join(groups, "\n"))
+	}
+	src.WriteString(strings.Join(g.decls, ""))
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("elasticmapper: generated bad source: %v", err)
+	}
+	return formatted, nil
+}
+
+// structGenerator holds the state of a GenerateStructs call.
+type structGenerator struct {
+	// decls are the struct declarations, parents before the structs of
+	// their fields.
+	decls     []string
+	imports   map[string]bool
+	typeNames map[string]bool
+	// templates are the dynamic templates that can become map values, keyed
+	// by their path_match.
+	templates map[string]*DynamicTemplate
+	// rootDynamic is the dynamic mode of the mapping, which goes into an
+	// Object marker in the root struct.
+	rootDynamic DynamicMode
+}
+
+// goField is a field of a generated struct.
+type goField struct {
+	Type    string
+	Options []string
+	// Lost lists what the tag can't express.
+	Lost []string
+}
+
+// writeStruct declares a struct named typeName with a field for each of
+// properties, which are at path in the document.
+func (g *structGenerator) writeStruct(typeName, path string, properties map[string]*Property, doc []string) error {
+	decl := len(g.decls)
+	g.decls = append(g.decls, "")
+	var body bytes.Buffer
+	fieldNames := make(map[string]bool)
+	if path == "" && g.rootDynamic != "" {
+		fmt.Fprintf(&body, "\t_ elasticmapper.Object `%s:\"dynamic=%s\"`\n", structTag, g.rootDynamic)
+	}
+	for _, name := range sortedKeys(properties) {
+		if !isValidJSONName(name) {
+			return &FieldError{Path: joinPath(path, name), Reason: "name can't be used in a json tag"}
+		}
+		fieldName := uniqueName(goName(name), fieldNames)
+		field, err := g.field(typeName+fieldName, joinPath(path, name), properties[name])
+		if err != nil {
+			return err
+		}
+		tag := fmt.Sprintf("json:%q", name)
+		if name == "-" {
+			tag = `json:"-,"`
+		}
+		if len(field.Options) > 0 {
+			tag += fmt.Sprintf(" %s:%q", structTag, strings.Join(field.Options, ","))
+		}
+		if len(field.Lost) > 0 {
+			fmt.Fprintf(&body, "\t// Not reproduced: %s\n", strings.Join(field.Lost, "; "))
+		}
+		fmt.Fprintf(&body, "\t%s %s `%s`\n", fieldName, field.Type, tag)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s is generated from a mapping.\n", typeName)
+	if len(doc) > 0 {
+		fmt.Fprintf(&b, "//\n// %s\n", strings.Join(doc, "\n// "))
+	}
+	fmt.Fprintf(&b, "type %s struct {\n%s}\n\n", typeName, body.String())
+	g.decls[decl] = b.String()
+	return nil
+}
+
+// field returns the Go type and tag options for property. typeName is the
+// name to give a struct for it, should it need one.
+func (g *structGenerator) field(typeName, path string, p *Property) (*goField, error) {
+	switch propertyType(p) {
+	case "object":
+		return g.objectField(typeName, path, p)
+	case "nested":
+		structName, err := g.nestedStruct(typeName, path, p.Properties)
+		if err != nil {
+			return nil, err
+		}
+		field := &goField{Type: "[]" + structName}
+		addDynamicOption(field, p.Dynamic)
+		addBoolOption(field, "include_in_parent", p.IncludeInParent)
+		addBoolOption(field, "include_in_root", p.IncludeInRoot)
+		field.Lost = append(field.Lost, extraParams(p)...)
+		return field, nil
+	}
+	return g.leafField(p)
+}
+
+// objectField returns the field for an object property, which is a struct
+// unless it's disabled or a map behind dynamic templates.
+func (g *structGenerator) objectField(typeName, path string, p *Property) (*goField, error) {
+	if p.Enabled != nil && !*p.Enabled {
+		g.imports["encoding/json"] = true
+		return &goField{Type: "json.RawMessage", Lost: extraParams(p)}, nil
+	}
+	if len(p.Properties) == 0 {
+		if value := g.templateValue(path); value != nil {
+			field, err := g.field(typeName+"Value", joinPath(path, "*"), value)
+			if err != nil {
+				return nil, err
+			}
+			field.Type = "map[string]" + field.Type
+			addDynamicOption(field, p.Dynamic)
+			return field, nil
+		}
+	}
+	structName, err := g.nestedStruct(typeName, path, p.Properties)
+	if err != nil {
+		return nil, err
+	}
+	field := &goField{Type: structName, Lost: extraParams(p)}
+	addDynamicOption(field, p.Dynamic)
+	if p.Enabled != nil {
+		addBoolOption(field, "enabled", p.Enabled)
+	}
+	return field, nil
+}
+
+// templateValue rebuilds the property of the values of a map at path from
+// the dynamic templates matching path.*, or returns nil if there are none.
+func (g *structGenerator) templateValue(path string) *Property {
+	prefix := joinPath(path, "*")
+	var value *Property
+	for _, pathMatch := range sortedTemplateKeys(g.templates) {
+		template := g.templates[pathMatch]
+		switch {
+		case pathMatch == prefix:
+			value = template.Mapping
+		case strings.HasPrefix(pathMatch, prefix+".") && (value == nil || propertyType(value) == "object"):
+			// A value of a map of structs, one template per leaf
+			if value == nil {
+				value = &Property{Type: "object", Properties: make(map[string]*Property)}
+			}
+			parent := value
+			names := strings.Split(strings.TrimPrefix(pathMatch, prefix+"."), ".")
+			for _, name := range names[:len(names)-1] {
+				child, ok := parent.Properties[name]
+				if !ok {
+					child = &Property{Type: "object", Properties: make(map[string]*Property)}
+					parent.Properties[name] = child
+				}
+				parent = child
+			}
+			parent.Properties[names[len(names)-1]] = template.Mapping
+		}
+	}
+	return value
+}
+
+func sortedTemplateKeys(templates map[string]*DynamicTemplate) []string {
+	keys := make([]string, 0, len(templates))
+	for k := range templates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nestedStruct declares a struct for the properties of an object and returns
+// its name.
+func (g *structGenerator) nestedStruct(typeName, path string, properties map[string]*Property) (string, error) {
+	typeName = uniqueName(typeName, g.typeNames)
+	return typeName, g.writeStruct(typeName, path, properties, nil)
+}
+
+// leafTypes are the Go types for fields with values, and the type to put in
+// the tag when the Go type alone would map to something else.
+var leafTypes = map[string]struct{ goType, tagType string }{
+	"keyword":            {"string", ""},
+	"text":               {"string", "text"},
+	"wildcard":           {"string", "wildcard"},
+	"match_only_text":    {"string", "match_only_text"},
+	"completion":         {"string", "completion"},
+	"search_as_you_type": {"string", "search_as_you_type"},
+	"long":               {"int64", ""},
+	"integer":            {"int32", "integer"},
+	"short":              {"int16", "short"},
+	"byte":               {"int8", "byte"},
+	"unsigned_long":      {"uint64", "unsigned_long"},
+	"double":             {"float64", ""},
+	"float":              {"float32", "float"},
+	"half_float":         {"float32", "half_float"},
+	"scaled_float":       {"float64", "scaled_float"},
+	"boolean":            {"bool", ""},
+	"date_nanos":         {"time.Time", "date_nanos"},
+	"ip":                 {"net.IP", ""},
+	"binary":             {"[]byte", ""},
+	"geo_point":          {"elasticmapper.GeoPoint", ""},
+	"geo_shape":          {"map[string]interface{}", "geo_shape"},
+	"flattened":          {"map[string]interface{}", "flattened"},
+	"flat_object":        {"map[string]interface{}", "flat_object"},
+	"integer_range":      {"map[string]interface{}", "integer_range"},
+	"long_range":         {"map[string]interface{}", "long_range"},
+	"float_range":        {"map[string]interface{}", "float_range"},
+	"double_range":       {"map[string]interface{}", "double_range"},
+	"date_range":         {"map[string]interface{}", "date_range"},
+	"ip_range":           {"map[string]interface{}", "ip_range"},
+	"knn_vector":         {"[]float32", "knn_vector"},
+	"join":               {"elasticmapper.Join", ""},
+}
+
+// leafField returns the field for a property holding values.
+func (g *structGenerator) leafField(p *Property) (*goField, error) {
+	field := &goField{}
+	tagType := p.Type
+	switch p.Type {
+	case "date":
+		// time.Time is written in the default format; anything else has
+		// to stay as it is
+		switch {
+		case p.Format == "":
+			field.Type, tagType = "time.Time", ""
+		case isEpochFormat(p.Format):
+			field.Type = "int64"
+		default:
+			field.Type = "string"
+		}
+	case "dense_vector":
+		if p.Dims != nil {
+			field.Type = fmt.Sprintf("[%d]float32", *p.Dims)
+		} else {
+			field.Type = "[]float32"
+		}
+	default:
+		leaf, ok := leafTypes[p.Type]
+		if !ok {
+			field.Type = "interface{}"
+			field.Lost = append(field.Lost, fmt.Sprintf("type %s", p.Type))
+			return field, nil
+		}
+		field.Type, tagType = leaf.goType, leaf.tagType
+	}
+	switch {
+	case strings.HasPrefix(field.Type, "time."):
+		g.imports["time"] = true
+	case strings.HasPrefix(field.Type, "net."):
+		g.imports["net"] = true
+	case strings.HasPrefix(field.Type, "elasticmapper."):
+		g.imports["github.com/wingedrhino/golang-snippets/elasticmapper"] = true
+	}
+	if tagType != "" {
+		field.Options = append(field.Options, tagType)
+	}
+	g.leafOptions(field, p)
+	return field, nil
+}
+
+// isEpochFormat reports whether a date format only takes numbers.
+func isEpochFormat(format string) bool {
+	for _, f := range strings.Split(format, "||") {
+		if f != "epoch_millis" && f != "epoch_second" {
+			return false
+		}
+	}
+	return true
+}
+
+// leafOptions adds the tag options for the parameters of p to field.
+func (g *structGenerator) leafOptions(field *goField, p *Property) {
+	add := func(key, value string) {
+		if strings.Contains(value, ",") {
+			field.Lost = append(field.Lost, fmt.Sprintf("%s %q", key, value))
+			return
+		}
+		field.Options = append(field.Options, key+"="+value)
+	}
+	addString := func(key, value string) {
+		if value != "" {
+			add(key, value)
+		}
+	}
+	addInt := func(key string, value *int) {
+		if value != nil {
+			add(key, strconv.Itoa(*value))
+		}
+	}
+	addString("analyzer", p.Analyzer)
+	addString("search_analyzer", p.SearchAnalyzer)
+	addString("normalizer", p.Normalizer)
+	addBoolOption(field, "index", p.Index)
+	addBoolOption(field, "doc_values", p.DocValues)
+	for _, target := range p.CopyTo {
+		add("copy_to", target)
+	}
+	addString("format", p.Format)
+	if p.NullValue != nil {
+		add("null_value", fmt.Sprint(p.NullValue))
+	}
+	addInt("ignore_above", p.IgnoreAbove)
+	if p.ScalingFactor != nil {
+		add("scaling_factor", strconv.FormatFloat(*p.ScalingFactor, 'g', -1, 64))
+	}
+	addInt("max_shingle_size", p.MaxShingleSize)
+	if p.Type == "dense_vector" && p.Dims == nil {
+		field.Lost = append(field.Lost, "dims, which dense_vector slices need")
+	}
+	addInt("dims", p.Dimension)
+	addString("similarity", p.Similarity)
+	for _, c := range p.Contexts {
+		value := c.Type
+		if c.Path != "" {
+			value += ":" + c.Path
+		}
+		add("context."+c.Name, value)
+		if c.Precision != nil {
+			field.Lost = append(field.Lost, fmt.Sprintf("precision of context %s", c.Name))
+		}
+	}
+	for _, name := range sortedKeys(p.Fields) {
+		multiField := p.Fields[name]
+		add("fields."+name, multiField.Type)
+		if params, err := propertyParams(multiField); err != nil || len(params) > 0 {
+			field.Lost = append(field.Lost, fmt.Sprintf("parameters of multi-field %s", name))
+		}
+	}
+	if p.Type == "join" {
+		g.joinOptions(field, p)
+	}
+	field.Lost = append(field.Lost, extraParams(p)...)
+}
+
+// joinOptions adds the relation of a join field, which a tag can only express
+// for a single parent with a single child.
+func (g *structGenerator) joinOptions(field *goField, p *Property) {
+	if len(p.Relations) != 1 {
+		field.Lost = append(field.Lost, "relations, which need a struct per relation and MapAll")
+		return
+	}
+	for parent, children := range p.Relations {
+		if len(children) != 1 {
+			field.Lost = append(field.Lost, "relations, which need a struct per relation and MapAll")
+			return
+		}
+		field.Options = append(field.Options, "relation="+children[0], "parent="+parent)
+	}
+}
+
+func addDynamicOption(field *goField, mode DynamicMode) {
+	if mode != "" {
+		field.Options = append(field.Options, "dynamic="+string(mode))
+	}
+}
+
+func addBoolOption(field *goField, key string, value *bool) {
+	if value != nil {
+		field.Options = append(field.Options, fmt.Sprintf("%s=%t", key, *value))
+	}
+}
+
+// extraParams lists the parameters of p this package doesn't know about.
+func extraParams(p *Property) []string {
+	var lost []string
+	for _, name := range sortedExtraKeys(p.Extra) {
+		lost = append(lost, fmt.Sprintf("%s %s", name, formatValue(p.Extra[name])))
+	}
+	return lost
+}
+
+func sortedExtraKeys(extra map[string]interface{}) []string {
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// initialisms are the words Go spells in capitals.
+var initialisms = stringSet("id", "ip", "url", "uri", "uuid", "http", "https", "json", "xml", "html", "api", "sql", "ttl", "tcp", "udp")
+
+// goName turns a property name like "first_name" or "user-id" into an
+// exported Go identifier like FirstName or UserID.
+func goName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, word := range words {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		runes := []rune(word)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	s := b.String()
+	if s == "" || !unicode.IsLetter([]rune(s)[0]) {
+		s = "F" + s
+	}
+	return s
+}
+
+// uniqueName returns name, or name with a number added if it's taken already,
+// and marks the result as taken.
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
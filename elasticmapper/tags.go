@@ -0,0 +1,437 @@
+package elasticmapper
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tagOptions is a parsed elasticmapper struct tag. A tag is a comma separated
+// list of options, where an option is either a bare word or a key=value pair:
+//
+//	`elasticmapper:"text,analyzer=english,fields.raw=keyword,copy_to=all_text"`
+//
+// The bare words are "-", which leaves the field out of the mapping, the name
+// of the ElasticSearch type to use for the field, and _id and _routing, which
+// make the field the id or routing of the document in bulk requests. Values can't contain
+// commas; options that take a list, like copy_to, are repeated instead.
+type tagOptions struct {
+	Skip bool
+	Type string
+	// ID and Routing mark the fields holding the _id and the routing of a
+	// document for BulkEncoder.
+	ID      bool
+	Routing bool
+
+	Analyzer       string
+	SearchAnalyzer string
+	Normalizer     string
+	// Fields are the multi-fields of the field, keyed by name, with the
+	// ElasticSearch type of each as the value.
+	Fields      map[string]string
+	Index       *bool
+	DocValues   *bool
+	CopyTo      []string
+	Format      string
+	NullValue   *string
+	IgnoreAbove *int
+	// ScalingFactor is required for scaled_float fields.
+	ScalingFactor  *float64
+	MaxShingleSize *int
+	// Contexts are the contexts of a completion field, in the order they
+	// appear in the tag.
+	Contexts   []*CompletionContext
+	Dims       *int
+	Similarity string
+
+	// Options for objects rather than values.
+	Dynamic         DynamicMode
+	Enabled         *bool
+	IncludeInParent *bool
+	IncludeInRoot   *bool
+
+	// Relation is the name of the struct in a join field, and Parent the
+	// name of its parent, if any.
+	Relation string
+	Parent   string
+}
+
+// similarities are the values of the similarity of a dense_vector.
+var similarities = stringSet("l2_norm", "dot_product", "cosine", "max_inner_product")
+
+// tagError is returned when a struct tag can't be parsed.
+type tagError struct {
+	Tag     string
+	Option  string
+	Problem string
+}
+
+func (e *tagError) Error() string {
+	return fmt.Sprintf("bad option %q in tag %q: %s", e.Option, e.Tag, e.Problem)
+}
+
+// isTypeName reports whether name is an ElasticSearch type a tag may ask for.
+func isTypeName(name string) bool {
+	for _, typeName := range typeNames {
+		if name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTag parses the value of an elasticmapper struct tag.
+func parseTag(tag string) (opts tagOptions, err error) {
+	if strings.TrimSpace(tag) == "" {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.Trim(option, " \t")
+		fail := func(format string, args ...interface{}) error {
+			return &tagError{Tag: tag, Option: option, Problem: fmt.Sprintf(format, args...)}
+		}
+		if option == "" {
+			return opts, fail("empty option")
+		}
+
+		eq := strings.Index(option, "=")
+		if eq == -1 {
+			switch {
+			case option == "-":
+				opts.Skip = true
+			case option == "_id":
+				opts.ID = true
+			case option == "_routing":
+				opts.Routing = true
+			case isTypeName(option):
+				if opts.Type != "" {
+					return opts, fail("type already set to %q", opts.Type)
+				}
+				opts.Type = option
+			default:
+				return opts, fail("unknown option")
+			}
+			continue
+		}
+
+		key := strings.TrimSpace(option[:eq])
+		value := strings.TrimSpace(option[eq+1:])
+		if key == "" || value == "" {
+			return opts, fail("expected key=value")
+		}
+		// copy_to may be repeated, everything else may only be set once
+		if seen[key] && key != "copy_to" {
+			return opts, fail("%s set twice", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "analyzer":
+			opts.Analyzer = value
+		case "search_analyzer":
+			opts.SearchAnalyzer = value
+		case "normalizer":
+			opts.Normalizer = value
+		case "index":
+			if opts.Index, err = parseBoolOption(value); err != nil {
+				return opts, fail("%v", err)
+			}
+		case "dynamic":
+			opts.Dynamic = DynamicMode(value)
+			if !opts.Dynamic.valid() {
+				return opts, fail("expected true, false, strict or runtime")
+			}
+		case "enabled":
+			if opts.Enabled, err = parseBoolOption(value); err != nil {
+				return opts, fail("%v", err)
+			}
+		case "include_in_parent":
+			if opts.IncludeInParent, err = parseBoolOption(value); err != nil {
+				return opts, fail("%v", err)
+			}
+		case "include_in_root":
+			if opts.IncludeInRoot, err = parseBoolOption(value); err != nil {
+				return opts, fail("%v", err)
+			}
+		case "relation":
+			opts.Relation = value
+		case "parent":
+			opts.Parent = value
+		case "doc_values":
+			if opts.DocValues, err = parseBoolOption(value); err != nil {
+				return opts, fail("%v", err)
+			}
+		case "copy_to":
+			opts.CopyTo = append(opts.CopyTo, value)
+		case "format":
+			opts.Format = value
+		case "null_value":
+			opts.NullValue = &value
+		case "ignore_above":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return opts, fail("expected a non-negative integer")
+			}
+			opts.IgnoreAbove = &n
+		case "max_shingle_size":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 2 || n > 4 {
+				return opts, fail("expected a number from 2 to 4")
+			}
+			opts.MaxShingleSize = &n
+		case "dims":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return opts, fail("expected a positive integer")
+			}
+			opts.Dims = &n
+		case "similarity":
+			if !similarities[value] {
+				return opts, fail("unknown similarity")
+			}
+			opts.Similarity = value
+		case "scaling_factor":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || f <= 0 {
+				return opts, fail("expected a positive number")
+			}
+			opts.ScalingFactor = &f
+		default:
+			if strings.HasPrefix(key, "context.") {
+				context, err := parseContext(strings.TrimPrefix(key, "context."), value)
+				if err != nil {
+					return opts, fail("%v", err)
+				}
+				opts.Contexts = append(opts.Contexts, context)
+				continue
+			}
+			if !strings.HasPrefix(key, "fields.") {
+				return opts, fail("unknown option")
+			}
+			name := strings.TrimPrefix(key, "fields.")
+			if name == "" || strings.Contains(name, ".") {
+				return opts, fail("bad multi-field name %q", name)
+			}
+			if !isTypeName(value) {
+				return opts, fail("unknown type %q", value)
+			}
+			if opts.Fields == nil {
+				opts.Fields = make(map[string]string)
+			}
+			opts.Fields[name] = value
+		}
+	}
+	return opts, nil
+}
+
+// parseContext parses a context.<name>=<type>[:<path>] option, e.g.
+// context.genre=category:genres for a category context taken from the genres
+// field.
+func parseContext(name, value string) (*CompletionContext, error) {
+	if name == "" || strings.Contains(name, ".") {
+		return nil, fmt.Errorf("bad context name %q", name)
+	}
+	context := &CompletionContext{Name: name, Type: value}
+	if colon := strings.Index(value, ":"); colon != -1 {
+		context.Type, context.Path = value[:colon], value[colon+1:]
+	}
+	if context.Type != "category" && context.Type != "geo" {
+		return nil, fmt.Errorf("context type must be category or geo, not %q", context.Type)
+	}
+	return context, nil
+}
+
+func parseBoolOption(value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.New("expected true or false")
+	}
+	return &b, nil
+}
+
+// leafOptionsSet reports whether opts holds any option that only makes sense
+// on a field with a value, as opposed to an object.
+func (opts tagOptions) leafOptionsSet() bool {
+	return opts.Analyzer != "" || opts.SearchAnalyzer != "" || opts.Normalizer != "" || len(opts.Fields) > 0 ||
+		opts.Index != nil || opts.DocValues != nil || len(opts.CopyTo) > 0 ||
+		opts.Format != "" || opts.NullValue != nil || opts.IgnoreAbove != nil || opts.ScalingFactor != nil ||
+		opts.MaxShingleSize != nil || len(opts.Contexts) > 0 || opts.Dims != nil || opts.Similarity != "" ||
+		opts.Relation != "" || opts.Parent != "" || opts.ID || opts.Routing
+}
+
+// applyObject sets the options in opts that apply to objects on property,
+// whose type must be object or nested.
+func (opts tagOptions) applyObject(property *Property) error {
+	typeName := propertyType(property)
+	if opts.Dynamic != "" {
+		property.Dynamic = opts.Dynamic
+	}
+	if opts.Enabled != nil {
+		if typeName != "object" {
+			return fmt.Errorf("enabled only applies to object fields, not %s", typeName)
+		}
+		property.Enabled = opts.Enabled
+	}
+	if opts.IncludeInParent != nil || opts.IncludeInRoot != nil {
+		if typeName != "nested" {
+			return fmt.Errorf("include_in_parent and include_in_root only apply to nested fields, not %s", typeName)
+		}
+		property.IncludeInParent = opts.IncludeInParent
+		property.IncludeInRoot = opts.IncludeInRoot
+	}
+	return nil
+}
+
+// apply sets the options in opts on property, whose type must already be set.
+// It fails for options that don't apply to that type.
+func (opts tagOptions) apply(property *Property) error {
+	typeName := property.Type
+	if opts.Dynamic != "" || opts.Enabled != nil || opts.IncludeInParent != nil || opts.IncludeInRoot != nil {
+		return fmt.Errorf("dynamic, enabled, include_in_parent and include_in_root only apply to objects, not %s", typeName)
+	}
+	if typeName == "join" {
+		if opts.Relation == "" {
+			return errors.New("join fields need the relation of the struct")
+		}
+		if opts.Parent != "" {
+			property.Relations = map[string]StringList{opts.Parent: {opts.Relation}}
+		}
+	} else if opts.Relation != "" || opts.Parent != "" {
+		return fmt.Errorf("relation and parent only apply to join fields, not %s", typeName)
+	}
+	if opts.Analyzer != "" || opts.SearchAnalyzer != "" {
+		if typeName != "text" && typeName != "search_as_you_type" && typeName != "completion" {
+			return fmt.Errorf("analyzers only apply to text fields, not %s", typeName)
+		}
+		property.Analyzer = opts.Analyzer
+		property.SearchAnalyzer = opts.SearchAnalyzer
+	}
+	if opts.Normalizer != "" {
+		if typeName != "keyword" {
+			return fmt.Errorf("normalizers only apply to keyword fields, not %s", typeName)
+		}
+		property.Normalizer = opts.Normalizer
+	}
+	if opts.IgnoreAbove != nil {
+		if typeName != "keyword" {
+			return fmt.Errorf("ignore_above only applies to keyword fields, not %s", typeName)
+		}
+		property.IgnoreAbove = opts.IgnoreAbove
+	}
+	if opts.Format != "" {
+		if typeName != "date" && typeName != "date_nanos" && typeName != "date_range" {
+			return fmt.Errorf("format only applies to date fields, not %s", typeName)
+		}
+		property.Format = opts.Format
+	}
+	if opts.ScalingFactor != nil {
+		if typeName != "scaled_float" {
+			return fmt.Errorf("scaling_factor only applies to scaled_float fields, not %s", typeName)
+		}
+		property.ScalingFactor = opts.ScalingFactor
+	}
+	if typeName == "scaled_float" && property.ScalingFactor == nil {
+		return errors.New("scaled_float fields need a scaling_factor")
+	}
+	if opts.MaxShingleSize != nil {
+		if typeName != "search_as_you_type" {
+			return fmt.Errorf("max_shingle_size only applies to search_as_you_type fields, not %s", typeName)
+		}
+		property.MaxShingleSize = opts.MaxShingleSize
+	}
+	if len(opts.Contexts) > 0 {
+		if typeName != "completion" {
+			return fmt.Errorf("contexts only apply to completion fields, not %s", typeName)
+		}
+		property.Contexts = opts.Contexts
+	}
+	if opts.Dims != nil {
+		switch typeName {
+		case "dense_vector":
+			property.Dims = opts.Dims
+		case "knn_vector":
+			property.Dimension = opts.Dims
+		default:
+			return fmt.Errorf("dims only applies to vector fields, not %s", typeName)
+		}
+	}
+	if typeName == "knn_vector" && property.Dimension == nil {
+		return errors.New("knn_vector fields need dims")
+	}
+	if opts.Similarity != "" {
+		if typeName != "dense_vector" {
+			return fmt.Errorf("similarity only applies to dense_vector fields, not %s", typeName)
+		}
+		property.Similarity = opts.Similarity
+		if property.Index == nil {
+			// Before 8.11 vectors aren't indexed by default, and a
+			// similarity is only allowed for indexed ones
+			index := true
+			property.Index = &index
+		}
+	}
+	if opts.Index != nil {
+		property.Index = opts.Index
+	}
+	if opts.DocValues != nil {
+		if typeName == "text" {
+			return errors.New("text fields don't support doc_values")
+		}
+		property.DocValues = opts.DocValues
+	}
+	if len(opts.CopyTo) > 0 {
+		property.CopyTo = opts.CopyTo
+	}
+	if opts.NullValue != nil {
+		nullValue, err := convertNullValue(*opts.NullValue, typeName)
+		if err != nil {
+			return err
+		}
+		property.NullValue = nullValue
+	}
+	if len(opts.Fields) > 0 {
+		property.Fields = make(map[string]*Property, len(opts.Fields))
+		for name, fieldType := range opts.Fields {
+			property.Fields[name] = &Property{Type: fieldType}
+		}
+	}
+	return nil
+}
+
+// convertNullValue turns the null_value from a tag into a value of the type
+// ElasticSearch expects for a field of the given type.
+func convertNullValue(value, typeName string) (interface{}, error) {
+	switch typeName {
+	case "text":
+		return nil, errors.New("text fields don't support null_value")
+	case "integer_range", "long_range", "float_range", "double_range", "date_range", "ip_range":
+		return nil, fmt.Errorf("%s fields don't support null_value", typeName)
+	case "unsigned_long":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("null_value %q is not a valid %s", value, typeName)
+		}
+		return n, nil
+	case "long", "integer", "short", "byte":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("null_value %q is not a valid %s", value, typeName)
+		}
+		return n, nil
+	case "double", "float", "half_float", "scaled_float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("null_value %q is not a valid %s", value, typeName)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("null_value %q is not a valid boolean", value)
+		}
+		return b, nil
+	}
+	return value, nil
+}
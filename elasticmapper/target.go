@@ -0,0 +1,142 @@
+package elasticmapper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Engine is a search engine that understands ElasticSearch style mappings.
+type Engine string
+
+// The engines a mapping can be generated for.
+const (
+	Elasticsearch Engine = "elasticsearch"
+	OpenSearch    Engine = "opensearch"
+)
+
+// Target is the engine and version a mapping is generated for. It decides the
+// layout of the mapping and which field types may be used. The zero Target
+// doesn't check field types and uses the legacy layout with a type name, which
+// is what this package always did.
+type Target struct {
+	Engine Engine
+	Major  int
+	Minor  int
+}
+
+// Targets for every supported release line. Lines that are done are pinned to
+// their last minor release, the others to their first.
+var (
+	Elasticsearch5 = Target{Elasticsearch, 5, 6}
+	Elasticsearch6 = Target{Elasticsearch, 6, 8}
+	Elasticsearch7 = Target{Elasticsearch, 7, 17}
+	Elasticsearch8 = Target{Elasticsearch, 8, 0}
+	OpenSearch1    = Target{OpenSearch, 1, 3}
+	OpenSearch2    = Target{OpenSearch, 2, 0}
+)
+
+// ParseTarget parses a target such as "elasticsearch-7.10", "es8" or
+// "opensearch-2". The minor version defaults to 0.
+func ParseTarget(s string) (Target, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexAny(lower, "-0123456789")
+	if i <= 0 {
+		return Target{}, fmt.Errorf("elasticmapper: unknown target %q", s)
+	}
+	var t Target
+	switch lower[:i] {
+	case "elasticsearch", "es":
+		t.Engine = Elasticsearch
+	case "opensearch", "os":
+		t.Engine = OpenSearch
+	default:
+		return Target{}, fmt.Errorf("elasticmapper: unknown target %q", s)
+	}
+	version := strings.SplitN(strings.TrimPrefix(lower[i:], "-"), ".", 3)
+	var err error
+	if t.Major, err = strconv.Atoi(version[0]); err != nil {
+		return Target{}, fmt.Errorf("elasticmapper: bad version in target %q", s)
+	}
+	if len(version) > 1 {
+		if t.Minor, err = strconv.Atoi(version[1]); err != nil {
+			return Target{}, fmt.Errorf("elasticmapper: bad version in target %q", s)
+		}
+	}
+	if err := t.validate(); err != nil {
+		return Target{}, err
+	}
+	return t, nil
+}
+
+func (t Target) String() string {
+	if t == (Target{}) {
+		return "legacy"
+	}
+	return fmt.Sprintf("%s-%d.%d", t.Engine, t.Major, t.Minor)
+}
+
+// validate checks that t is the zero Target or a version we know about.
+func (t Target) validate() error {
+	switch {
+	case t == (Target{}):
+	case t.Engine == Elasticsearch && t.Major >= 5:
+	case t.Engine == OpenSearch && t.Major >= 1:
+	default:
+		return fmt.Errorf("elasticmapper: unsupported target %s", t)
+	}
+	return nil
+}
+
+// Typeless reports whether the target wants mappings without a type name,
+// which is the case for ElasticSearch 7 onwards and every OpenSearch.
+func (t Target) Typeless() bool {
+	return t.Engine == OpenSearch || t.Engine == Elasticsearch && t.Major >= 7
+}
+
+// atLeast reports whether t is version v or later.
+func (t Target) atLeast(v version) bool {
+	return t.Major > v.major || t.Major == v.major && t.Minor >= v.minor
+}
+
+type version struct{ major, minor int }
+
+// typeSupport lists the field types that aren't available everywhere, with the
+// first version of each engine that has them. A missing engine means it
+// doesn't support the type at all.
+var typeSupport = map[string]map[Engine]version{
+	"flattened":          {Elasticsearch: {7, 3}},
+	"flat_object":        {OpenSearch: {2, 7}},
+	"dense_vector":       {Elasticsearch: {7, 0}},
+	"knn_vector":         {OpenSearch: {1, 0}},
+	"search_as_you_type": {Elasticsearch: {7, 2}, OpenSearch: {1, 0}},
+	"date_nanos":         {Elasticsearch: {7, 0}, OpenSearch: {1, 0}},
+	"rank_feature":       {Elasticsearch: {7, 0}, OpenSearch: {1, 0}},
+	"wildcard":           {Elasticsearch: {7, 9}, OpenSearch: {2, 15}},
+	"match_only_text":    {Elasticsearch: {7, 14}, OpenSearch: {2, 12}},
+	"unsigned_long":      {Elasticsearch: {7, 10}, OpenSearch: {2, 8}},
+	"join":               {Elasticsearch: {6, 0}, OpenSearch: {1, 0}},
+}
+
+// SupportsType reports whether fields of the given ElasticSearch type can be
+// used with the target. The zero Target supports everything.
+func (t Target) SupportsType(typeName string) bool {
+	if t == (Target{}) {
+		return true
+	}
+	since, ok := typeSupport[typeName]
+	if !ok {
+		return true
+	}
+	v, ok := since[t.Engine]
+	return ok && t.atLeast(v)
+}
+
+// wrap returns what goes under "mappings" for the target: the mapping itself
+// for typeless targets, or the mapping keyed by typeName for older ones.
+func (t Target) wrap(mapping *Mapping, typeName string) interface{} {
+	if t.Typeless() {
+		return mapping
+	}
+	return map[string]*Mapping{typeName: mapping}
+}
@@ -0,0 +1,754 @@
+package elasticmapper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValueError is a problem with a single value of a document.
+type ValueError struct {
+	// Pointer is the JSON pointer (RFC 6901) of the value within the
+	// document, like "/votes/2/user".
+	Pointer string
+	Reason  string
+	// Rejected is true if ElasticSearch would reject the whole document.
+	// Otherwise the document is indexed, but the value isn't indexed the
+	// way it's written: it's truncated, say, or left out.
+	Rejected bool
+}
+
+func (e *ValueError) Error() string {
+	return "elasticmapper: " + e.describe()
+}
+
+func (e *ValueError) describe() string {
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Reason)
+}
+
+// DocumentError is returned by ValidateDocument for a document that doesn't
+// fit a mapping. It lists all the problems rather than stopping at the first
+// one.
+type DocumentError struct {
+	Values []*ValueError
+}
+
+func (e *DocumentError) Error() string {
+	problems := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		problems[i] = v.describe()
+	}
+	return fmt.Sprintf("elasticmapper: %d problem(s) with the document: %s", len(e.Values), strings.Join(problems, "; "))
+}
+
+// Rejected reports whether ElasticSearch would reject the document.
+func (e *DocumentError) Rejected() bool {
+	for _, v := range e.Values {
+		if v.Rejected {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate is ValidateDocument for the JSON encoding of v.
+func Validate(mapping *Mapping, v interface{}) error {
+	document, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ValidateDocument(mapping, document)
+}
+
+// ValidateDocument checks a JSON document against mapping before it's
+// indexed, and returns a *DocumentError listing what ElasticSearch would
+// reject or index differently than it's written:
+//
+//   - fields that aren't in the mapping, where it's strict
+//   - values that don't fit the type of their field, like a word in a number
+//     field, or a number with a fraction in an integer one
+//   - keywords longer than ignore_above, which aren't indexed
+//   - dates that don't match the format of their field
+//
+// Values of fields that aren't in the mapping are only checked if a dynamic
+// template applies to them. Date formats with pattern letters other than the
+// usual year, month, day and time ones aren't checked.
+func ValidateDocument(mapping *Mapping, document []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(document))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return &DocumentError{Values: []*ValueError{{Reason: "the document must be an object, not " + jsonKind(value), Rejected: true}}}
+	}
+	v := &validator{templates: mapping.DynamicTemplates}
+	dynamic := mapping.Dynamic
+	if dynamic == "" {
+		dynamic = DynamicTrue
+	}
+	for _, name := range sortedMembers(doc) {
+		if metadataFields[name] {
+			v.reject(jsonPointer("", name), "%s is a metadata field and can't be set in the document", name)
+			continue
+		}
+		v.member(jsonPointer("", name), "", mapping.Properties, dynamic, name, doc[name])
+	}
+	if len(v.problems) > 0 {
+		return &DocumentError{Values: v.problems}
+	}
+	return nil
+}
+
+// metadataFields are the fields ElasticSearch keeps next to the document,
+// which can't be in it.
+var metadataFields = map[string]bool{
+	"_id": true, "_index": true, "_routing": true, "_source": true, "_type": true,
+	"_version": true, "_seq_no": true, "_primary_term": true, "_field_names": true,
+	"_ignored": true, "_tier": true,
+}
+
+type validator struct {
+	templates []*DynamicTemplate
+	problems  []*ValueError
+}
+
+func (v *validator) reject(pointer, format string, args ...interface{}) {
+	v.problems = append(v.problems, &ValueError{Pointer: pointer, Reason: fmt.Sprintf(format, args...), Rejected: true})
+}
+
+func (v *validator) warn(pointer, format string, args ...interface{}) {
+	v.problems = append(v.problems, &ValueError{Pointer: pointer, Reason: fmt.Sprintf(format, args...)})
+}
+
+// member checks the member name of an object whose fields are properties.
+// ElasticSearch reads a name with dots in it as a path through objects, so
+// {"a.b": 1} is the same as {"a": {"b": 1}}.
+func (v *validator) member(pointer, path string, properties map[string]*Property, dynamic DynamicMode, name string, value interface{}) {
+	fieldPath := joinPath(path, name)
+	if p, ok := properties[name]; ok {
+		v.value(pointer, fieldPath, p, dynamic, value)
+		return
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if p, ok := properties[name[:i]]; ok && isObjectProperty(p) {
+			if p.Enabled != nil && !*p.Enabled {
+				return
+			}
+			v.member(pointer, joinPath(path, name[:i]), p.Properties, childDynamic(p, dynamic), name[i+1:], value)
+			return
+		}
+	}
+	switch dynamic {
+	case DynamicStrict:
+		v.reject(pointer, "%s isn't in the mapping, and dynamic is strict", fieldPath)
+	case DynamicTrue:
+		if template := v.template(fieldPath, value); template != nil {
+			v.value(pointer, fieldPath, template.Mapping, DynamicTrue, value)
+		}
+	}
+}
+
+// template returns the first dynamic template that applies to a new field at
+// path, if any.
+func (v *validator) template(path string, value interface{}) *DynamicTemplate {
+	name := path[strings.LastIndex(path, ".")+1:]
+	for _, t := range v.templates {
+		if t.Match != "" && !globMatch(t.Match, name) || t.Unmatch != "" && globMatch(t.Unmatch, name) ||
+			t.PathMatch != "" && !globMatch(t.PathMatch, path) || t.PathUnmatch != "" && globMatch(t.PathUnmatch, path) {
+			continue
+		}
+		if t.MatchMappingType != "" && t.MatchMappingType != "*" && t.MatchMappingType != dynamicType(value) {
+			continue
+		}
+		return t
+	}
+	return nil
+}
+
+// value checks value against the property p of the field at path.
+func (v *validator) value(pointer, path string, p *Property, dynamic DynamicMode, value interface{}) {
+	if value == nil {
+		return
+	}
+	typeName := propertyType(p)
+	if elements, ok := value.([]interface{}); ok && !arrayValue(typeName, elements) {
+		// ElasticSearch has no array type; each element is a value of the
+		// field
+		for i, element := range elements {
+			v.value(pointer+"/"+strconv.Itoa(i), path, p, dynamic, element)
+		}
+		return
+	}
+	switch typeName {
+	case "object", "nested":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.reject(pointer, "%s is an object, not %s", path, jsonKind(value))
+			return
+		}
+		if p.Enabled != nil && !*p.Enabled {
+			return
+		}
+		for _, name := range sortedMembers(object) {
+			v.member(jsonPointer(pointer, name), path, p.Properties, childDynamic(p, dynamic), name, object[name])
+		}
+	case "flattened", "flat_object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			v.reject(pointer, "%s is %s, not %s", path, typeName, jsonKind(value))
+		}
+	case "keyword", "wildcard", "text", "match_only_text", "search_as_you_type":
+		s, ok := scalarString(value)
+		if !ok {
+			v.reject(pointer, "%s is %s, not %s", path, typeName, jsonKind(value))
+			return
+		}
+		if typeName == "keyword" && p.IgnoreAbove != nil && utf8.RuneCountInString(s) > *p.IgnoreAbove {
+			v.warn(pointer, "%s is longer than its ignore_above of %d, so it isn't indexed", path, *p.IgnoreAbove)
+		}
+		for _, name := range sortedKeys(p.Fields) {
+			f := p.Fields[name]
+			if f.Type == "keyword" && f.IgnoreAbove != nil && utf8.RuneCountInString(s) > *f.IgnoreAbove {
+				v.warn(pointer, "%s is longer than the ignore_above of %s of %d, so it isn't indexed there", path, joinPath(path, name), *f.IgnoreAbove)
+			}
+		}
+	case "long", "integer", "short", "byte", "unsigned_long", "double", "float", "half_float", "scaled_float":
+		v.number(pointer, path, typeName, value)
+	case "boolean":
+		switch b := value.(type) {
+		case bool:
+		case string:
+			if b != "true" && b != "false" && b != "" {
+				v.reject(pointer, "%s is boolean, and %q isn't one", path, b)
+			}
+		default:
+			v.reject(pointer, "%s is boolean, not %s", path, jsonKind(value))
+		}
+	case "date", "date_nanos":
+		v.date(pointer, path, typeName, p.Format, value)
+	case "ip":
+		if s, ok := value.(string); !ok || net.ParseIP(s) == nil {
+			v.reject(pointer, "%s is ip, and %s isn't an IP address", path, describeValue(value))
+		}
+	case "binary":
+		s, ok := value.(string)
+		if !ok {
+			v.reject(pointer, "%s is binary, not %s", path, jsonKind(value))
+		} else if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			v.reject(pointer, "%s is binary, and isn't valid base64: %v", path, err)
+		}
+	case "integer_range", "long_range", "float_range", "double_range", "date_range", "ip_range":
+		v.rangeValue(pointer, path, typeName, p.Format, value)
+	case "geo_point":
+		v.geoPoint(pointer, path, value)
+	case "completion":
+		switch c := value.(type) {
+		case string:
+		case map[string]interface{}:
+			if _, ok := c["input"]; !ok {
+				v.reject(pointer, "%s is completion, and the suggestion has no input", path)
+			}
+		default:
+			v.reject(pointer, "%s is completion, not %s", path, jsonKind(value))
+		}
+	case "dense_vector", "knn_vector":
+		v.vector(pointer, path, p, value)
+	case "join":
+		v.join(pointer, path, p, value)
+	}
+}
+
+// arrayValue reports whether elements is a single value of a field of type
+// typeName, rather than several.
+func arrayValue(typeName string, elements []interface{}) bool {
+	switch typeName {
+	case "dense_vector", "knn_vector":
+		return true
+	case "geo_point":
+		// [lon, lat]
+		if len(elements) < 2 || len(elements) > 3 {
+			return false
+		}
+		for _, e := range elements {
+			if _, ok := e.(json.Number); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// integerRanges are the values the integer types can hold.
+var integerRanges = map[string][2]float64{
+	"byte":    {math.MinInt8, math.MaxInt8},
+	"short":   {math.MinInt16, math.MaxInt16},
+	"integer": {math.MinInt32, math.MaxInt32},
+	"long":    {math.MinInt64, math.MaxInt64},
+}
+
+// floatMax are the largest finite values of the floating point types.
+var floatMax = map[string]float64{
+	"half_float": 65504,
+	"float":      math.MaxFloat32,
+}
+
+// number checks a value of a numeric field. ElasticSearch takes numbers in
+// strings too.
+func (v *validator) number(pointer, path, typeName string, value interface{}) {
+	var s string
+	switch n := value.(type) {
+	case json.Number:
+		s = string(n)
+	case string:
+		s = strings.TrimSpace(n)
+	default:
+		v.reject(pointer, "%s is %s, not %s", path, typeName, jsonKind(value))
+		return
+	}
+	if typeName == "unsigned_long" {
+		if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return
+		}
+	} else if bounds, ok := integerRanges[typeName]; ok {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			if float64(i) < bounds[0] || float64(i) > bounds[1] {
+				v.reject(pointer, "%s is %s, and %s is out of its range", path, typeName, s)
+			}
+			return
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			v.reject(pointer, "%s is %s, and %s is out of its range", path, typeName, s)
+			return
+		}
+		v.reject(pointer, "%s is %s, and %q isn't a number", path, typeName, s)
+		return
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		v.reject(pointer, "%s is %s, and only takes finite numbers", path, typeName)
+		return
+	}
+	if max, ok := floatMax[typeName]; ok && math.Abs(f) > max {
+		v.reject(pointer, "%s is %s, and %s is out of its range", path, typeName, s)
+		return
+	}
+	bounds, integer := integerRanges[typeName]
+	if typeName == "unsigned_long" {
+		bounds, integer = [2]float64{0, math.MaxUint64}, true
+	}
+	if !integer {
+		return
+	}
+	t := math.Trunc(f)
+	if t < bounds[0] || t > bounds[1] {
+		v.reject(pointer, "%s is %s, and %s is out of its range", path, typeName, s)
+	} else if t != f {
+		v.warn(pointer, "%s is %s, so %s is truncated to %s", path, typeName, s, strconv.FormatFloat(t, 'f', -1, 64))
+	}
+}
+
+// date checks a value of a date field against its format.
+func (v *validator) date(pointer, path, typeName, format string, value interface{}) {
+	var s string
+	switch d := value.(type) {
+	case string:
+		s = d
+	case json.Number:
+		s = string(d)
+	default:
+		v.reject(pointer, "%s is %s, not %s", path, typeName, jsonKind(value))
+		return
+	}
+	if format == "" {
+		format = "strict_date_optional_time||epoch_millis"
+		if typeName == "date_nanos" {
+			format = "strict_date_optional_time_nanos||epoch_millis"
+		}
+	}
+	if ok, known := matchDateFormat(format, s); known && !ok {
+		v.reject(pointer, "%s is %s, and %q doesn't match its format %s", path, typeName, s, format)
+	}
+}
+
+// rangeValue checks a value of a range field, an object with the bounds of
+// the range. ip_range fields also take CIDR blocks.
+func (v *validator) rangeValue(pointer, path, typeName, format string, value interface{}) {
+	if s, ok := value.(string); ok && typeName == "ip_range" {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			v.reject(pointer, "%s is ip_range, and %q isn't a CIDR block", path, s)
+		}
+		return
+	}
+	bounds, ok := value.(map[string]interface{})
+	if !ok {
+		v.reject(pointer, "%s is %s, not %s", path, typeName, jsonKind(value))
+		return
+	}
+	elementType := strings.TrimSuffix(typeName, "_range")
+	for _, name := range sortedMembers(bounds) {
+		bound, boundPointer := bounds[name], jsonPointer(pointer, name)
+		switch name {
+		case "gt", "gte", "lt", "lte":
+		default:
+			v.reject(boundPointer, "%s is %s, and %s isn't a bound of a range", path, typeName, name)
+			continue
+		}
+		if bound == nil {
+			continue
+		}
+		switch elementType {
+		case "date":
+			v.date(boundPointer, path, "date_range", format, bound)
+		case "ip":
+			if s, ok := bound.(string); !ok || net.ParseIP(s) == nil {
+				v.reject(boundPointer, "%s is ip_range, and %s isn't an IP address", path, describeValue(bound))
+			}
+		default:
+			v.number(boundPointer, path, elementType, bound)
+		}
+	}
+}
+
+// geohashPattern matches geohashes, which geo_point fields take as strings.
+var geohashPattern = regexp.MustCompile(`^[0-9b-hjkmnp-z]{1,12}$`)
+
+// geoPoint checks a value of a geo_point field, which is either an object
+// with lat and lon, [lon, lat], "lat,lon", a geohash or a WKT point.
+func (v *validator) geoPoint(pointer, path string, value interface{}) {
+	var lat, lon interface{}
+	switch g := value.(type) {
+	case map[string]interface{}:
+		if g["type"] != nil && g["coordinates"] != nil {
+			// GeoJSON
+			return
+		}
+		lat, lon = g["lat"], g["lon"]
+	case []interface{}:
+		lon, lat = g[0], g[1]
+	case string:
+		if parts := strings.Split(g, ","); len(parts) == 2 {
+			lat, lon = json.Number(strings.TrimSpace(parts[0])), json.Number(strings.TrimSpace(parts[1]))
+		} else if geohashPattern.MatchString(g) || strings.HasPrefix(strings.ToUpper(g), "POINT") {
+			return
+		} else {
+			v.reject(pointer, "%s is geo_point, and %q isn't a point", path, g)
+			return
+		}
+	default:
+		v.reject(pointer, "%s is geo_point, not %s", path, jsonKind(value))
+		return
+	}
+	for _, c := range []struct {
+		name  string
+		value interface{}
+		max   float64
+	}{{"latitude", lat, 90}, {"longitude", lon, 180}} {
+		n, ok := c.value.(json.Number)
+		if !ok {
+			v.reject(pointer, "%s is geo_point, and its %s is missing or not a number", path, c.name)
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			v.reject(pointer, "%s is geo_point, and its %s %q isn't a number", path, c.name, n)
+			return
+		}
+		if math.Abs(f) > c.max {
+			v.reject(pointer, "%s is geo_point, and its %s %s is out of range", path, c.name, n)
+			return
+		}
+	}
+}
+
+// vector checks a value of a dense_vector or knn_vector field.
+func (v *validator) vector(pointer, path string, p *Property, value interface{}) {
+	elements, ok := value.([]interface{})
+	if !ok {
+		v.reject(pointer, "%s is %s, not %s", path, p.Type, jsonKind(value))
+		return
+	}
+	dims := p.Dims
+	if p.Type == "knn_vector" {
+		dims = p.Dimension
+	}
+	if dims != nil && len(elements) != *dims {
+		v.reject(pointer, "%s is %s with %d dimensions, not %d", path, p.Type, *dims, len(elements))
+	}
+	for i, element := range elements {
+		if _, ok := element.(json.Number); !ok {
+			v.reject(pointer+"/"+strconv.Itoa(i), "%s is %s, and its elements are numbers, not %s", path, p.Type, jsonKind(element))
+		}
+	}
+}
+
+// join checks a value of a join field: the name of a relation, and the id of
+// the parent for children.
+func (v *validator) join(pointer, path string, p *Property, value interface{}) {
+	var name, parent interface{}
+	switch j := value.(type) {
+	case string:
+		name = j
+	case map[string]interface{}:
+		name, parent = j["name"], j["parent"]
+	default:
+		v.reject(pointer, "%s is join, not %s", path, jsonKind(value))
+		return
+	}
+	relation, ok := name.(string)
+	if !ok {
+		v.reject(pointer, "%s is join, and its name is missing or not a string", path)
+		return
+	}
+	if _, isParent := p.Relations[relation]; isParent {
+		return
+	}
+	for _, children := range p.Relations {
+		for _, child := range children {
+			if child == relation {
+				if parent == nil {
+					v.reject(pointer, "%s is join, and %s documents need a parent", path, relation)
+				}
+				return
+			}
+		}
+	}
+	v.reject(pointer, "%s is join, and %q isn't one of its relations", path, relation)
+}
+
+// isObjectProperty reports whether p has properties of its own.
+func isObjectProperty(p *Property) bool {
+	t := propertyType(p)
+	return t == "object" || t == "nested"
+}
+
+// childDynamic returns the dynamic mode of the fields of the object p, whose
+// parent has dynamic.
+func childDynamic(p *Property, dynamic DynamicMode) DynamicMode {
+	if p.Dynamic != "" {
+		return p.Dynamic
+	}
+	return dynamic
+}
+
+// dynamicType returns the type ElasticSearch detects for a new field holding
+// value, as used by match_mapping_type.
+func dynamicType(value interface{}) string {
+	switch t := value.(type) {
+	case []interface{}:
+		for _, element := range t {
+			if element != nil {
+				return dynamicType(element)
+			}
+		}
+		return ""
+	case map[string]interface{}:
+		return "object"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return "long"
+		}
+		return "double"
+	}
+	return ""
+}
+
+// globMatch matches s against a pattern of ElasticSearch's simple match,
+// where * matches any number of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// scalarString returns a string, number or boolean as text fields see it.
+func scalarString(value interface{}) (string, bool) {
+	switch s := value.(type) {
+	case string:
+		return s, true
+	case json.Number:
+		return string(s), true
+	case bool:
+		return strconv.FormatBool(s), true
+	}
+	return "", false
+}
+
+// jsonKind describes the JSON type of value.
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// describeValue quotes strings and describes anything else by its kind.
+func describeValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return jsonKind(value)
+}
+
+// jsonPointer appends the member name to pointer, escaping it as RFC 6901
+// says.
+func jsonPointer(pointer, name string) string {
+	return pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func sortedMembers(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isoDateTime matches strict_date_optional_time: a date, optionally with a
+// time and a zone.
+var isoDateTime = `\d{4}(-\d{2}(-\d{2}(T\d{2}(:\d{2}(:\d{2}([.,]\d{1,9})?)?)?)?(Z|[+-]\d{2}(:?\d{2})?)?)?)?`
+
+// dateFormats are patterns for the built-in date formats. The formats
+// without the strict_ prefix take fewer digits for some parts, which this
+// doesn't bother with.
+var dateFormats = map[string]*regexp.Regexp{
+	"epoch_millis":                    regexp.MustCompile(`^-?\d+(\.\d+)?$`),
+	"epoch_second":                    regexp.MustCompile(`^-?\d+(\.\d+)?$`),
+	"strict_date_optional_time":       regexp.MustCompile(`^` + isoDateTime + `$`),
+	"strict_date_optional_time_nanos": regexp.MustCompile(`^` + isoDateTime + `$`),
+	"date_optional_time":              regexp.MustCompile(`^` + isoDateTime + `$`),
+	"strict_date":                     regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"strict_date_time":                regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{1,9}(Z|[+-]\d{2}:\d{2})$`),
+	"strict_date_time_no_millis":      regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(Z|[+-]\d{2}:\d{2})$`),
+	"strict_date_hour_minute_second":  regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}$`),
+	"strict_year_month_day":           regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"strict_year_month":               regexp.MustCompile(`^\d{4}-\d{2}$`),
+	"strict_year":                     regexp.MustCompile(`^\d{4}$`),
+	"strict_hour_minute_second":       regexp.MustCompile(`^\d{2}:\d{2}:\d{2}$`),
+	"basic_date":                      regexp.MustCompile(`^\d{8}$`),
+	"basic_date_time":                 regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}(Z|[+-]\d{4})$`),
+	"basic_date_time_no_millis":       regexp.MustCompile(`^\d{8}T\d{6}(Z|[+-]\d{4})$`),
+}
+
+// leadingDate matches values starting with a calendar date, whose day is
+// checked too.
+var leadingDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+// matchDateFormat reports whether s matches one of the formats separated by
+// ||. known is false if a format can't be checked.
+func matchDateFormat(format, s string) (ok, known bool) {
+	for _, f := range strings.Split(format, "||") {
+		pattern := dateFormats[f]
+		if pattern == nil && !strings.HasPrefix(f, "strict_") {
+			pattern = dateFormats["strict_"+f]
+		}
+		if pattern == nil {
+			pattern = javaDatePattern(f)
+		}
+		if pattern == nil {
+			return false, false
+		}
+		if !pattern.MatchString(s) {
+			continue
+		}
+		if d := leadingDate.FindString(s); d != "" {
+			if _, err := time.Parse("2006-01-02", d); err != nil {
+				continue
+			}
+		}
+		return true, true
+	}
+	return false, true
+}
+
+// javaDateLetters are the patterns for the letters of custom date formats,
+// by the number of times they're repeated.
+var javaDateLetters = map[byte]func(n int) string{
+	'y': digits, 'u': digits, 'M': digits, 'd': digits, 'H': digits,
+	'h': digits, 'm': digits, 's': digits, 'S': digits,
+	'a': func(int) string { return `(AM|PM|am|pm)` },
+	'X': func(int) string { return `(Z|[+-]\d{2}(:?\d{2})?)` },
+	'Z': func(int) string { return `[+-]\d{2}:?\d{2}` },
+}
+
+func digits(n int) string {
+	if n == 1 {
+		return `\d{1,2}`
+	}
+	return fmt.Sprintf(`\d{%d}`, n)
+}
+
+// javaDatePattern turns a custom date format like "yyyy-MM-dd HH:mm:ss" into
+// a pattern, or returns nil if it has letters this doesn't know.
+func javaDatePattern(format string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for i := 0; i < len(format); {
+		c := format[i]
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return nil
+			}
+			pattern.WriteString(regexp.QuoteMeta(format[i+1 : i+1+end]))
+			i += end + 2
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			letter := javaDateLetters[c]
+			if letter == nil {
+				return nil
+			}
+			n := 1
+			for i+n < len(format) && format[i+n] == c {
+				n++
+			}
+			pattern.WriteString(letter(n))
+			i += n
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+			i++
+		}
+	}
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
@@ -0,0 +1,428 @@
+// Package deepdiff compares two Go values of the same type and lists what
+// changed between them, each change addressed by its path like "N.C",
+// "P[3].D" or `W["key"]`, the way structwalk writes paths. The changes can be
+// turned into a JSON Patch (RFC 6902) for the JSON form of the values.
+package deepdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wingedrhino/golang-snippets/reflection/typecache"
+)
+
+// Op is the kind of a Change.
+type Op int
+
+const (
+	// Added is a map entry or slice element that's only in the new value.
+	Added Op = iota
+	// Removed is a map entry or slice element that's only in the old value.
+	Removed
+	// Modified is a value that's in both, but different.
+	Modified
+)
+
+func (o Op) String() string {
+	switch o {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "Op(" + strconv.Itoa(int(o)) + ")"
+}
+
+// Change is a difference between two values.
+type Change struct {
+	Op Op
+	// Path is the path of the value from the root, which is "".
+	Path string
+	// From is the old value, unless it was Added, and To the new one,
+	// unless it was Removed.
+	From, To interface{}
+
+	// pointer is the JSON Pointer of the value, and inJSON is false if it's
+	// under a field the JSON form leaves out. fromOmitted and toOmitted are
+	// set if omitempty leaves the old or new value out.
+	pointer                string
+	inJSON                 bool
+	fromOmitted, toOmitted bool
+}
+
+func (c Change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch c.Op {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", path, format(c.To))
+	case Removed:
+		return fmt.Sprintf("- %s: %s", path, format(c.From))
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", path, format(c.From), format(c.To))
+}
+
+func format(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+// Differ holds the settings of a comparison. Like a structwalk.Walker, it
+// caches what it learns about struct types, so reuse one and don't change or
+// copy it after its first use. A Differ is safe for concurrent use.
+type Differ struct {
+	// Tag is the name of the struct tag marking fields to leave out of the
+	// comparison, with `diff:"ignore"` or `diff:"-"`. It defaults to "diff".
+	Tag string
+
+	once  sync.Once
+	cache *typecache.Cache
+}
+
+var defaultDiffer Differ
+
+// Diff compares a and b with the zero Differ.
+func Diff(a, b interface{}) ([]Change, error) {
+	return defaultDiffer.Diff(a, b)
+}
+
+// Diff returns the changes that turn a into b, which must be of the same
+// type. Only exported struct fields are compared. A nil slice or map equals an
+// empty one, and NaN equals NaN. Slices are compared element by element, so
+// an element inserted at the front shows up as every element changing and one
+// being added at the end. Funcs and channels are only compared by whether
+// they're nil.
+//
+// A field whose json tag has omitempty, and which becomes empty or stops being
+// empty, changes as a whole, since the JSON form gains or loses it.
+//
+// Changes come in the order they can be applied: removed slice elements come
+// last first, so their indexes stay right.
+func (d *Differ) Diff(a, b interface{}) ([]Change, error) {
+	d.once.Do(func() {
+		tag := d.Tag
+		if tag == "" {
+			tag = "diff"
+		}
+		d.cache = &typecache.Cache{Tag: tag}
+	})
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.IsValid() != vb.IsValid() || (va.IsValid() && va.Type() != vb.Type()) {
+		return nil, fmt.Errorf("deepdiff: can't compare %T with %T", a, b)
+	}
+	c := &comparison{d: d, visited: make(map[visit]bool)}
+	if err := c.diff(va, vb, "", "", true); err != nil {
+		return nil, err
+	}
+	return c.changes, nil
+}
+
+// visit is a pair of pointers being compared further up, as in
+// reflect.DeepEqual. Meeting the pair again means the values are cyclic, so
+// it's skipped. The lengths of slices are part of it, since a slice and a
+// shorter one of the same array start at the same pointer.
+type visit struct {
+	a, b       uintptr
+	lenA, lenB int
+	typ        reflect.Type
+}
+
+type comparison struct {
+	d       *Differ
+	visited map[visit]bool
+	changes []Change
+}
+
+func (c *comparison) add(op Op, path, pointer string, inJSON bool, a, b reflect.Value) {
+	change := Change{Op: op, Path: path, pointer: pointer, inJSON: inJSON}
+	if op != Added {
+		change.From = value(a)
+	}
+	if op != Removed {
+		change.To = value(b)
+	}
+	c.changes = append(c.changes, change)
+}
+
+func value(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func (c *comparison) diff(a, b reflect.Value, path, pointer string, inJSON bool) error {
+	if !a.IsValid() || !b.IsValid() {
+		if a.IsValid() != b.IsValid() {
+			c.add(Modified, path, pointer, inJSON, a, b)
+		}
+		return nil
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		// A nil becoming something, or the other way round, changes the
+		// whole value, like null does in JSON
+		if a.IsNil() != b.IsNil() {
+			if a.Kind() == reflect.Ptr || a.Len() > 0 || b.Len() > 0 {
+				c.add(Modified, path, pointer, inJSON, a, b)
+			}
+			return nil
+		}
+		if a.Pointer() == b.Pointer() && a.Kind() != reflect.Slice {
+			return nil
+		}
+		if a.Pointer() != 0 && b.Pointer() != 0 {
+			key := visit{a: a.Pointer(), b: b.Pointer(), typ: a.Type()}
+			if a.Kind() == reflect.Slice {
+				key.lenA, key.lenB = a.Len(), b.Len()
+			}
+			if c.visited[key] {
+				return nil
+			}
+			c.visited[key] = true
+			defer delete(c.visited, key)
+		}
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() {
+			return nil
+		}
+		return c.diff(a.Elem(), b.Elem(), path, pointer, inJSON)
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type() {
+			if a.IsNil() != b.IsNil() || !a.IsNil() {
+				c.add(Modified, path, pointer, inJSON, a, b)
+			}
+			return nil
+		}
+		return c.diff(a.Elem(), b.Elem(), path, pointer, inJSON)
+	case reflect.Struct:
+		st, err := c.d.cache.Struct(a.Type())
+		if err != nil {
+			return err
+		}
+		for i := range st.Fields {
+			field := &st.Fields[i]
+			if field.Tag.Has("ignore") || field.Tag.Has("-") {
+				continue
+			}
+			fieldPath := field.Name
+			if path != "" {
+				fieldPath = path + "." + field.Name
+			}
+			name, omitEmpty, ok := jsonName(field.StructField)
+			fieldPointer := pointer + "/" + escape(name)
+			fa, fb := field.Value(a), field.Value(b)
+			if inJSON && ok && omitEmpty {
+				if emptyA, emptyB := isEmptyJSON(fa), isEmptyJSON(fb); emptyA != emptyB {
+					c.add(Modified, fieldPath, fieldPointer, true, fa, fb)
+					change := &c.changes[len(c.changes)-1]
+					change.fromOmitted, change.toOmitted = emptyA, emptyB
+					continue
+				}
+			}
+			if err := c.diff(fa, fb, fieldPath, fieldPointer, inJSON && ok); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		n := a.Len()
+		if b.Len() < n {
+			n = b.Len()
+		}
+		for i := 0; i < n; i++ {
+			if err := c.diff(a.Index(i), b.Index(i), fmt.Sprintf("%s[%d]", path, i), pointer+"/"+strconv.Itoa(i), inJSON); err != nil {
+				return err
+			}
+		}
+		for i := n; i < b.Len(); i++ {
+			c.add(Added, fmt.Sprintf("%s[%d]", path, i), pointer+"/"+strconv.Itoa(i), inJSON, reflect.Value{}, b.Index(i))
+		}
+		for i := a.Len() - 1; i >= n; i-- {
+			c.add(Removed, fmt.Sprintf("%s[%d]", path, i), pointer+"/"+strconv.Itoa(i), inJSON, a.Index(i), reflect.Value{})
+		}
+	case reflect.Map:
+		keys := a.MapKeys()
+		for _, key := range b.MapKeys() {
+			if !a.MapIndex(key).IsValid() {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, key := range keys {
+			entryPath := fmt.Sprintf("%s[%s]", path, formatKey(key))
+			entryPointer := pointer + "/" + escape(fmt.Sprint(key))
+			va, vb := a.MapIndex(key), b.MapIndex(key)
+			switch {
+			case !va.IsValid():
+				c.add(Added, entryPath, entryPointer, inJSON, va, vb)
+			case !vb.IsValid():
+				c.add(Removed, entryPath, entryPointer, inJSON, va, vb)
+			default:
+				if err := c.diff(va, vb, entryPath, entryPointer, inJSON); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		if a.IsNil() != b.IsNil() {
+			c.add(Modified, path, pointer, inJSON, a, b)
+		}
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+		if x != y && !(math.IsNaN(x) && math.IsNaN(y)) {
+			c.add(Modified, path, pointer, inJSON, a, b)
+		}
+	case reflect.Complex64, reflect.Complex128:
+		if a.Complex() != b.Complex() {
+			c.add(Modified, path, pointer, inJSON, a, b)
+		}
+	default:
+		// What's left are bools, integers and strings
+		if a.Interface() != b.Interface() {
+			c.add(Modified, path, pointer, inJSON, a, b)
+		}
+	}
+	return nil
+}
+
+// jsonName returns the name encoding/json gives a field and whether it has
+// omitempty, and false if it leaves the field out.
+func jsonName(field reflect.StructField) (name string, omitEmpty, ok bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	if parts[0] != "" {
+		return parts[0], omitEmpty, true
+	}
+	return field.Name, omitEmpty, true
+}
+
+// isEmptyJSON reports whether omitempty leaves v out, as in encoding/json.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNil reports whether v is missing or a nil pointer, map, slice or
+// interface, which is null in JSON.
+func isNil(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// escape escapes a token of a JSON Pointer (RFC 6901).
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// formatKey writes a map key for a path, quoting strings.
+func formatKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return strconv.Quote(key.String())
+	}
+	return fmt.Sprint(key)
+}
+
+// Operation is an operation of a JSON Patch.
+type Operation struct {
+	// Op is "add", "remove" or "replace".
+	Op string
+	// Path is a JSON Pointer to the value.
+	Path string
+	// Value is the value to add or replace with.
+	Value interface{}
+}
+
+// MarshalJSON writes o the way RFC 6902 has it, with a value unless it's a
+// remove.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// JSONPatch turns changes into a JSON Patch (RFC 6902) that makes the same
+// changes to the JSON form of the old value. Pointers use the names in json
+// tags, and changes to fields left out with `json:"-"` are left out too. A
+// value that was null or left out by omitempty is added rather than replaced,
+// since replace needs something to replace, and one omitempty leaves out now
+// is removed.
+// Embedded structs and custom MarshalJSON methods aren't taken into account,
+// so the patch only fits values whose JSON mirrors their Go structure.
+func JSONPatch(changes []Change) []Operation {
+	var patch []Operation
+	for _, change := range changes {
+		if !change.inJSON {
+			continue
+		}
+		switch change.Op {
+		case Added:
+			patch = append(patch, Operation{Op: "add", Path: change.pointer, Value: change.To})
+		case Removed:
+			patch = append(patch, Operation{Op: "remove", Path: change.pointer})
+		case Modified:
+			switch {
+			case change.toOmitted:
+				if !change.fromOmitted {
+					patch = append(patch, Operation{Op: "remove", Path: change.pointer})
+				}
+			case change.fromOmitted || isNil(change.From):
+				patch = append(patch, Operation{Op: "add", Path: change.pointer, Value: change.To})
+			default:
+				patch = append(patch, Operation{Op: "replace", Path: change.pointer, Value: change.To})
+			}
+		}
+	}
+	return patch
+}
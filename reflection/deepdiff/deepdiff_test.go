@@ -0,0 +1,291 @@
+package deepdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func paths(t *testing.T, a, b interface{}) []string {
+	t.Helper()
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	return paths
+}
+
+func TestDiffAliasedPointers(t *testing.T) {
+	type T struct{ X, Y *int }
+	p, q := 1, 2
+	got := paths(t, T{X: &p, Y: &p}, T{X: &q, Y: &q})
+	if want := []string{"X", "Y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes at %q, want %q", got, want)
+	}
+}
+
+func TestDiffSubslices(t *testing.T) {
+	type U struct{ A, B []int }
+	arr, arr2 := []int{1, 2, 3}, []int{1, 2, 4}
+	got := paths(t, U{A: arr[:2], B: arr}, U{A: arr2[:2], B: arr2})
+	if want := []string{"B[2]"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes at %q, want %q", got, want)
+	}
+}
+
+func TestDiffCycle(t *testing.T) {
+	type node struct {
+		Value int
+		Next  *node
+	}
+	a := &node{Value: 1}
+	a.Next = a
+	b := &node{Value: 2}
+	b.Next = b
+	got := paths(t, a, b)
+	if want := []string{"Value"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes at %q, want %q", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type inner struct{ C string }
+	type T struct {
+		S       string
+		Ignored string `diff:"ignore"`
+		Dash    string `diff:"-"`
+		F       float64
+		M       map[string]int
+		I       interface{}
+		L       []int
+		A       [2]int
+		P       *inner
+	}
+	nan := math.NaN()
+	tests := []struct {
+		name string
+		a, b T
+		want []string
+	}{
+		{"equal", T{S: "x"}, T{S: "x"}, nil},
+		{"field", T{S: "x"}, T{S: "y"}, []string{`~ S: "x" -> "y"`}},
+		{"ignored", T{Ignored: "x", Dash: "x"}, T{Ignored: "y", Dash: "y"}, nil},
+		{"NaN", T{F: nan}, T{F: nan}, nil},
+		{"NaN to number", T{F: nan}, T{F: 1}, []string{"~ F: NaN -> 1"}},
+		{"map entries", T{M: map[string]int{"a": 1, "b": 2}}, T{M: map[string]int{"b": 3, "c": 4}},
+			[]string{`- M["a"]: 1`, `~ M["b"]: 2 -> 3`, `+ M["c"]: 4`}},
+		{"nil map equals empty", T{M: map[string]int{}}, T{}, nil},
+		{"nil map to entries", T{}, T{M: map[string]int{"a": 1}}, []string{"~ M: map[] -> map[a:1]"}},
+		{"interface value", T{I: 1}, T{I: 2}, []string{"~ I: 1 -> 2"}},
+		{"interface type", T{I: 1}, T{I: "1"}, []string{`~ I: 1 -> "1"`}},
+		{"interface nil", T{}, T{I: 1}, []string{"~ I: <nil> -> 1"}},
+		{"slice grows", T{L: []int{1}}, T{L: []int{2, 3, 4}}, []string{"~ L[0]: 1 -> 2", "+ L[1]: 3", "+ L[2]: 4"}},
+		{"slice shrinks last first", T{L: []int{1, 2, 3}}, T{L: []int{1}}, []string{"- L[2]: 3", "- L[1]: 2"}},
+		{"array", T{A: [2]int{1, 2}}, T{A: [2]int{1, 3}}, []string{"~ A[1]: 2 -> 3"}},
+		{"pointer fields", T{P: &inner{"x"}}, T{P: &inner{"y"}}, []string{`~ P.C: "x" -> "y"`}},
+	}
+	for _, test := range tests {
+		changes, err := Diff(test.a, test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, change := range changes {
+			got = append(got, change.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, err := Diff(1, "1"); err == nil {
+		t.Error("comparing an int with a string didn't fail")
+	}
+	changes, err := Diff(1, 2)
+	if err != nil || len(changes) != 1 || changes[0].String() != "~ (root): 1 -> 2" {
+		t.Errorf("diffing the root got %v, %v", changes, err)
+	}
+}
+
+type patchInner struct {
+	C string `json:"c"`
+	D int    `json:"d,omitempty"`
+}
+
+type patchDoc struct {
+	S      string         `json:"s"`
+	O      string         `json:"o,omitempty"`
+	P      *patchInner    `json:"p,omitempty"`
+	Q      *patchInner    `json:"q"`
+	M      map[string]int `json:"m,omitempty"`
+	N      map[string]int `json:"n"`
+	L      []int          `json:"l,omitempty"`
+	K      []string       `json:"k"`
+	I      interface{}    `json:"i"`
+	Hidden string         `json:"-"`
+	Plain  patchInner
+	A      [2]int `json:"a/b~c"`
+}
+
+func TestJSONPatch(t *testing.T) {
+	base := func() patchDoc {
+		return patchDoc{S: "s", N: map[string]int{"a": 1}, K: []string{"x"}, Plain: patchInner{C: "c"}}
+	}
+	setP := func(d *patchDoc) { d.P = &patchInner{C: "x"} }
+	clearN := func(d *patchDoc) { d.N = nil }
+	tests := []struct {
+		name          string
+		before, after func(d *patchDoc)
+		want          []string
+	}{
+		{"omitempty pointer set", nil, setP, []string{"add /p"}},
+		{"omitempty pointer cleared", setP, nil, []string{"remove /p"}},
+		{"omitempty pointer changed", setP, func(d *patchDoc) { d.P = &patchInner{C: "y", D: 1} },
+			[]string{"replace /p/c", "add /p/d"}},
+		{"pointer set", nil, func(d *patchDoc) { d.Q = &patchInner{C: "x"} }, []string{"add /q"}},
+		{"pointer cleared", func(d *patchDoc) { d.Q = &patchInner{C: "x"} }, nil, []string{"replace /q"}},
+		{"omitempty string set", nil, func(d *patchDoc) { d.O = "x" }, []string{"add /o"}},
+		{"omitempty string cleared", func(d *patchDoc) { d.O = "x" }, nil, []string{"remove /o"}},
+		{"omitempty map set", nil, func(d *patchDoc) { d.M = map[string]int{"a": 1} }, []string{"add /m"}},
+		{"omitempty slice set", nil, func(d *patchDoc) { d.L = []int{1, 2} }, []string{"add /l"}},
+		{"omitempty slice emptied", func(d *patchDoc) { d.L = []int{1} }, func(d *patchDoc) { d.L = []int{} }, []string{"remove /l"}},
+		{"map cleared", nil, clearN, []string{"replace /n"}},
+		{"nil map set", clearN, nil, []string{"add /n"}},
+		{"map entries", nil, func(d *patchDoc) { d.N = map[string]int{"b": 2} }, []string{"remove /n/a", "add /n/b"}},
+		{"nil interface set", nil, func(d *patchDoc) { d.I = "x" }, []string{"add /i"}},
+		{"interface changed", func(d *patchDoc) { d.I = 1 }, func(d *patchDoc) { d.I = "x" }, []string{"replace /i"}},
+		{"slice", nil, func(d *patchDoc) { d.K = []string{"y", "z"} }, []string{"replace /k/0", "add /k/1"}},
+		{"slice shrinks", func(d *patchDoc) { d.K = []string{"x", "y", "z"} }, nil, []string{"remove /k/2", "remove /k/1"}},
+		{"omitempty int inside", nil, func(d *patchDoc) { d.Plain.D = 3 }, []string{"add /Plain/d"}},
+		{"escaped name", nil, func(d *patchDoc) { d.A[1] = 1 }, []string{"replace /a~1b~0c/1"}},
+		{"hidden", nil, func(d *patchDoc) { d.Hidden = "x" }, nil},
+	}
+	for _, test := range tests {
+		a, b := base(), base()
+		if test.before != nil {
+			test.before(&a)
+		}
+		if test.after != nil {
+			test.after(&b)
+		}
+		changes, err := Diff(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patch := JSONPatch(changes)
+		var ops []string
+		for _, op := range patch {
+			ops = append(ops, op.Op+" "+op.Path)
+		}
+		if !reflect.DeepEqual(ops, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, ops, test.want)
+		}
+
+		doc := decode(t, a)
+		for _, op := range patch {
+			if doc, err = apply(doc, op); err != nil {
+				t.Fatalf("%s: applying %s %s: %v", test.name, op.Op, op.Path, err)
+			}
+		}
+		if want := decode(t, b); !reflect.DeepEqual(doc, want) {
+			t.Errorf("%s: patched document is %v, want %v", test.name, doc, want)
+		}
+	}
+}
+
+func TestOperationJSON(t *testing.T) {
+	got, err := json.Marshal([]Operation{{Op: "add", Path: "/p", Value: nil}, {Op: "remove", Path: "/q"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"op":"add","path":"/p","value":null},{"op":"remove","path":"/q"}]`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// decode returns the JSON form of v as maps, slices and values.
+func decode(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// apply applies an operation to doc the way RFC 6902 has it, failing where
+// the RFC says to, like on a replace of a member that doesn't exist.
+func apply(doc interface{}, op Operation) (interface{}, error) {
+	var value interface{}
+	if op.Op != "remove" {
+		data, err := json.Marshal(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	}
+	tokens := strings.Split(op.Path, "/")[1:]
+	for i := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tokens[i])
+	}
+	return applyAt(doc, tokens, op.Op, value)
+}
+
+func applyAt(doc interface{}, tokens []string, op string, value interface{}) (interface{}, error) {
+	last := len(tokens) == 1
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		member, ok := container[tokens[0]]
+		if !last {
+			if !ok {
+				return nil, fmt.Errorf("no member %s", tokens[0])
+			}
+			child, err := applyAt(member, tokens[1:], op, value)
+			container[tokens[0]] = child
+			return container, err
+		}
+		if !ok && op != "add" {
+			return nil, fmt.Errorf("no member %s to %s", tokens[0], op)
+		}
+		if op == "remove" {
+			delete(container, tokens[0])
+		} else {
+			container[tokens[0]] = value
+		}
+		return container, nil
+	case []interface{}:
+		i, err := strconv.Atoi(tokens[0])
+		if err != nil || i < 0 || i > len(container) || (i == len(container) && !(last && op == "add")) {
+			return nil, fmt.Errorf("bad index %s", tokens[0])
+		}
+		if !last {
+			child, err := applyAt(container[i], tokens[1:], op, value)
+			container[i] = child
+			return container, err
+		}
+		switch op {
+		case "add":
+			container = append(container[:i], append([]interface{}{value}, container[i:]...)...)
+		case "remove":
+			container = append(container[:i], container[i+1:]...)
+		default:
+			container[i] = value
+		}
+		return container, nil
+	}
+	return nil, fmt.Errorf("can't go into %v", doc)
+}
@@ -0,0 +1,3 @@
+module github.com/wingedrhino/golang-snippets/reflection
+
+go 1.22
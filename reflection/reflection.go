@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"log"
 	"reflect"
-	"strings"
+
+	"github.com/wingedrhino/golang-snippets/reflection/deepdiff"
+	"github.com/wingedrhino/golang-snippets/reflection/structwalk"
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+	"github.com/wingedrhino/golang-snippets/reflection/validate"
 )
 
 // MyType is a sample type for testing purposes
@@ -38,6 +42,36 @@ func (m MyType3) Foo() {
 
 }
 
+// BadTag is a sample type whose tag doesn't fit customTagSchema
+type BadTag struct {
+	E string `customtag:"hello,label='Hi, there',world=yes"`
+}
+
+// customTagSchema lists the options customtag understands
+var customTagSchema = tagopts.Schema{
+	"noscan":     {Type: tagopts.Flag},
+	"world":      {Type: tagopts.Flag},
+	"hello":      {Type: tagopts.Flag},
+	"decorateme": {Type: tagopts.Flag},
+	"idonothing": {Type: tagopts.Flag},
+	"label":      {Type: tagopts.String},
+}
+
+// SignUp is a sample type for validation
+type SignUp struct {
+	Username string   `validate:"required,min=3,max=64,regexp=^[a-z0-9_]+$"`
+	Email    string   `validate:"required,email"`
+	Plan     string   `validate:"oneof=free pro team"`
+	Password string   `validate:"required,min=8"`
+	Confirm  string   `validate:"eqfield=Password"`
+	Invites  []Invite `validate:"max=3"`
+}
+
+// Invite is a sample type for validation
+type Invite struct {
+	Email string `validate:"required,email"`
+}
+
 // MyTypeInner represents an inner type
 type MyTypeInner interface {
 	Foo()
@@ -52,70 +86,87 @@ func main() {
 	}
 	log.Printf("JSON representation (for reference):\n%s\n\n", string(jsonBytes))
 	printStruct(myVar)
+
+	// Quoted values can hold commas, and mistakes are reported with the
+	// field and tag they're in
+	options, err := tagopts.Parse(`hello,label='Hi, there',world=yes`)
+	log.Printf("Options of BadTag.E: %+v (error: %v)\n", options, err)
+	err = (&structwalk.Walker{Tag: "customtag", Schema: customTagSchema}).Walk(BadTag{}, structwalk.Funcs{})
+	log.Printf("Walking BadTag: %v\n", err)
+
+	// Compare myVar with a changed copy. O has json:"-", so it's left out of
+	// the JSON Patch.
+	changed := myVar
+	changed.N = MyType3{"Yikes!", 13}
+	changed.O = MyType2{"New"}
+	changed.P.D = 7
+	changes, err := deepdiff.Diff(myVar, changed)
+	if err != nil {
+		log.Fatalf("Error in deepdiff.Diff: %v\n", err)
+	}
+	for _, change := range changes {
+		log.Printf("Change: %v\n", change)
+	}
+	patch, err := json.Marshal(deepdiff.JSONPatch(changes))
+	if err != nil {
+		log.Fatalf("Error in json.Marshal: %v\n", err)
+	}
+	log.Printf("JSON Patch: %s\n", patch)
+
+	// Every rule broken is listed, with its path
+	signUp := SignUp{
+		Username: "Jo",
+		Email:    "jo@example.com",
+		Plan:     "enterprise",
+		Password: "hunter22",
+		Confirm:  "hunter2",
+		Invites:  []Invite{{"sam@example.com"}, {"not an address"}},
+	}
+	if err := validate.Struct(signUp); err != nil {
+		log.Printf("Validating SignUp: %v\n", err)
+	}
 }
 
 func printStruct(input interface{}) {
-	inputType := reflect.TypeOf(input)
-	inputValue := reflect.ValueOf(input)
-	log.Printf("Kind of '%v': %s\n", input, inputType.Kind())
-
-	// In the actual software you write,
-	switch inputType.Kind() {
-	case reflect.Struct:
-		// Debug code: This should never execute
-		if inputValue.NumField() != inputType.NumField() {
-			log.Fatalf("Mismatch between NumField() of ValueOf (%d) and TypeOf (%d). Exiting!\n", inputValue.NumField(), inputType.NumField())
-		}
-		for i := 0; i < inputType.NumField(); i++ {
-			fieldType := inputType.Field(i)
-			fieldValue := inputValue.Field(i)
-			tagString := fieldType.Tag.Get("customtag")
-			tagLookup := getTagLookup(tagString)
-			if tagLookup["noscan"] {
-				log.Printf("Encountered field %s with noscan set. Continuing.\n", fieldType.Name)
-				continue
+	walker := structwalk.Walker{Tag: "customtag", Schema: customTagSchema}
+	err := walker.Walk(input, structwalk.Funcs{
+		PreFunc: func(f *structwalk.Field) error {
+			if f.Tag.Has("noscan") {
+				log.Printf("Encountered field %s with noscan set. Continuing.\n", f.Path)
+				return structwalk.SkipChildren
 			}
-			if tagLookup["world"] {
-				log.Printf("Encountered field %s with 'world' tag. It's useless\n", fieldType.Name)
+			if f.Tag.Has("world") {
+				log.Printf("Encountered field %s with 'world' tag. It's useless\n", f.Path)
 			}
-			if tagLookup["hello"] {
-				log.Printf("Encountered field %s with 'hello' tag. It's useless\n", fieldType.Name)
+			if f.Tag.Has("hello") {
+				log.Printf("Encountered field %s with 'hello' tag. It's useless\n", f.Path)
 			}
-
-			decorate := tagLookup["decorateme"]
-
-			if decorate {
+			if f.Tag.Has("decorateme") {
 				log.Printf("~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~\n")
 			}
-			innerValue := fieldValue.Interface()
-			// If current element is assigned via an interface, it could be nil
-			// This helps.
-			if innerValue != nil {
-				printStruct(innerValue)
+			value := f.Elem()
+			switch value.Kind() {
+			case reflect.Invalid:
+				// If current element is assigned via an interface, it could
+				// be nil
+				log.Printf("Encountered nil at '%s'\n", f.Path)
+			case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+				log.Printf("Kind of '%s': %s\n", f.Path, value.Kind())
+			case reflect.String:
+				log.Printf("Encountered string at '%s': '%s'\n", f.Path, value.String())
+			default:
+				log.Printf("Encountered %s at '%s': %v\n", value.Kind(), f.Path, value)
 			}
-			if decorate {
+			return nil
+		},
+		PostFunc: func(f *structwalk.Field) error {
+			if f.Tag.Has("decorateme") {
 				log.Printf("~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~\n")
 			}
-		}
-	case reflect.Int64:
-		log.Printf("Encountered Int64: %d\n", input)
-	case reflect.String:
-		log.Printf("Encountered string: '%s'\n", input)
-	default:
-		// I don't have time to write a general-purpose parser here  so let off
-		log.Printf("Unsupported type. reflect.Type value is : %d\n", inputType.Kind())
-	}
-}
-
-// getTagLookup returns a lookup table for a custom struct tag's options.
-// For example, if you set mytag="hello,world", the options "hello" and "world"
-// are active according to convention.
-func getTagLookup(tagString string) map[string]bool {
-	lookup := make(map[string]bool)
-	tags := strings.Split(tagString, ",")
-	for _, tag := range tags {
-		trimmedTag := strings.Trim(tag, " \t")
-		lookup[trimmedTag] = true
+			return nil
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error walking %v: %v\n", input, err)
 	}
-	return lookup
 }
@@ -0,0 +1,281 @@
+// Package structwalk walks a Go value with reflection, calling a Visitor for
+// the value itself and everything it holds: the fields of structs, the
+// elements of slices and arrays and the entries of maps, following pointers
+// and interfaces on the way. Every value comes with its path from the root,
+// like "N.C", "P[3].D" or `W["key"].C`, and fields with their
+// reflect.StructField and parsed struct tag.
+package structwalk
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+	"github.com/wingedrhino/golang-snippets/reflection/typecache"
+)
+
+// SkipChildren is returned by Visitor.Pre to walk past the children of a
+// value.
+var SkipChildren = errors.New("skip children")
+
+// Stop is returned by a Visitor to end the walk early. Walk returns nil then.
+var Stop = errors.New("stop walk")
+
+// Visitor is called for every value of a walk. Pre is called before the
+// children of a value are walked and Post after, so they nest like opening
+// and closing tags. Returning SkipChildren from Pre skips the children, and
+// Post isn't called then either. Returning Stop from either ends the walk, and
+// any other error ends it with that error.
+type Visitor interface {
+	Pre(f *Field) error
+	Post(f *Field) error
+}
+
+// Funcs turns functions into a Visitor. Either may be nil.
+type Funcs struct {
+	PreFunc  func(f *Field) error
+	PostFunc func(f *Field) error
+}
+
+// Pre implements Visitor.
+func (v Funcs) Pre(f *Field) error {
+	if v.PreFunc == nil {
+		return nil
+	}
+	return v.PreFunc(f)
+}
+
+// Post implements Visitor.
+func (v Funcs) Post(f *Field) error {
+	if v.PostFunc == nil {
+		return nil
+	}
+	return v.PostFunc(f)
+}
+
+// Kind says what holds a value.
+type Kind int
+
+const (
+	// Root is the value passed to Walk.
+	Root Kind = iota
+	// StructField is a field of a struct.
+	StructField
+	// Element is an element of a slice or array.
+	Element
+	// MapEntry is the value of a map entry.
+	MapEntry
+)
+
+// Field is a value of a walk, along with where it was found.
+type Field struct {
+	Kind Kind
+	// Path is the path of the value from the root, which is "". Fields are
+	// added with a dot, elements with their index in brackets and map
+	// entries with their key in brackets, quoted if it's a string.
+	Path string
+	// Depth is how many structs, slices, arrays and maps hold the value.
+	Depth int
+	// Parent is the field holding this one, or nil for the root.
+	Parent *Field
+
+	// StructField is the struct field, for fields.
+	StructField reflect.StructField
+	// Tag holds the options of the struct tag named by Walker.Tag, for
+	// fields.
+	Tag tagopts.Options
+	// Index is the index of an element.
+	Index int
+	// Key is the key of a map entry.
+	Key reflect.Value
+
+	// Value is the value as it's stored, which may be a pointer or an
+	// interface. It's settable if the walk started from a pointer and
+	// there's no map on the way.
+	Value reflect.Value
+	// Cycle is set if the value is, or points to, a value being walked
+	// already, further up. Its children aren't walked again.
+	Cycle bool
+}
+
+// Elem returns the value f holds, following pointers and interfaces. It
+// returns the zero Value if there's a nil on the way.
+func (f *Field) Elem() reflect.Value {
+	v := f.Value
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// Name returns the name of a struct field, and "" for anything else.
+func (f *Field) Name() string {
+	if f.Kind != StructField {
+		return ""
+	}
+	return f.StructField.Name
+}
+
+// Walker holds the settings of a walk. The zero Walker walks exported fields
+// only, without parsing tags.
+//
+// A Walker remembers the fields and tags of the struct types it meets, so
+// reusing one for many walks is much faster than making a new one each time.
+// Its settings must not change after the first walk, and it must not be
+// copied then. A Walker is safe for concurrent use.
+type Walker struct {
+	// Tag is the name of the struct tag to parse into Field.Tag.
+	Tag string
+	// Schema, if set, is checked against the tag of every field. A tag that
+	// doesn't fit ends the walk with a *tagopts.FieldError.
+	Schema tagopts.Schema
+	// Unexported walks unexported fields too. Their values can be read with
+	// reflect but not turned back into interfaces or set.
+	Unexported bool
+
+	once  sync.Once
+	cache *typecache.Cache
+}
+
+// defaultWalker is the Walker of Walk, so its cache is shared.
+var defaultWalker Walker
+
+// Walk walks value with the zero Walker.
+func Walk(value interface{}, v Visitor) error {
+	return defaultWalker.Walk(value, v)
+}
+
+// Walk calls v for value and everything it holds, depth first. Struct fields
+// are walked in declaration order, map entries in the order of their keys.
+func (w *Walker) Walk(value interface{}, v Visitor) error {
+	w.once.Do(func() {
+		w.cache = &typecache.Cache{Tag: w.Tag, Schema: w.Schema, Unexported: w.Unexported}
+	})
+	rv := reflect.ValueOf(value)
+	walking := make(map[visit]bool)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		// Walk what the pointer points to, so values can be set, but still
+		// notice the pointer coming back
+		walking[visit{rv.Pointer(), rv.Type()}] = true
+		rv = rv.Elem()
+	}
+	err := w.walk(&Field{Kind: Root, Value: rv}, v, walking)
+	if err == Stop {
+		return nil
+	}
+	return err
+}
+
+// visit is a pointer being walked, as in encoding/json's cycle detection.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+func (w *Walker) walk(f *Field, v Visitor, walking map[visit]bool) error {
+	// Follow pointers, watching for ones already being walked further up
+	elem := f.Value
+	for elem.IsValid() && (elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface) {
+		if elem.IsNil() {
+			elem = reflect.Value{}
+			break
+		}
+		if elem.Kind() == reflect.Ptr {
+			key := visit{elem.Pointer(), elem.Type()}
+			if walking[key] {
+				f.Cycle = true
+				elem = reflect.Value{}
+				break
+			}
+			walking[key] = true
+			defer delete(walking, key)
+		}
+		elem = elem.Elem()
+	}
+	// Maps and slices can hold themselves too, through interfaces
+	if (elem.Kind() == reflect.Map || elem.Kind() == reflect.Slice) && elem.Len() > 0 {
+		key := visit{elem.Pointer(), elem.Type()}
+		if walking[key] {
+			f.Cycle = true
+			elem = reflect.Value{}
+		} else {
+			walking[key] = true
+			defer delete(walking, key)
+		}
+	}
+
+	if err := v.Pre(f); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+	if elem.IsValid() {
+		if err := w.children(f, elem, v, walking); err != nil {
+			return err
+		}
+	}
+	return v.Post(f)
+}
+
+// children walks the fields, elements or entries of elem, the value f holds.
+func (w *Walker) children(f *Field, elem reflect.Value, v Visitor, walking map[visit]bool) error {
+	child := func(kind Kind, path string, value reflect.Value) *Field {
+		return &Field{Kind: kind, Path: path, Depth: f.Depth + 1, Parent: f, Value: value}
+	}
+	switch elem.Kind() {
+	case reflect.Struct:
+		st, err := w.cache.Struct(elem.Type())
+		if err != nil {
+			return err
+		}
+		for i := range st.Fields {
+			field := &st.Fields[i]
+			path := field.Name
+			if f.Path != "" {
+				path = f.Path + "." + field.Name
+			}
+			c := child(StructField, path, field.Value(elem))
+			c.StructField = field.StructField
+			c.Tag = field.Tag
+			if err := w.walk(c, v, walking); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < elem.Len(); i++ {
+			c := child(Element, fmt.Sprintf("%s[%d]", f.Path, i), elem.Index(i))
+			c.Index = i
+			if err := w.walk(c, v, walking); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := elem.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, key := range keys {
+			c := child(MapEntry, fmt.Sprintf("%s[%s]", f.Path, formatKey(key)), elem.MapIndex(key))
+			c.Key = key
+			if err := w.walk(c, v, walking); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatKey writes a map key for a path, quoting strings.
+func formatKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return fmt.Sprintf("%q", key.String())
+	}
+	return fmt.Sprint(key)
+}
@@ -0,0 +1,371 @@
+package structwalk
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+)
+
+type leaf struct{ D int }
+
+type tree struct {
+	N   leaf
+	P   []leaf
+	W   map[string]leaf
+	M   map[int]string
+	I   interface{}
+	Ptr *leaf
+}
+
+// record walks value with w and returns what happened, each Pre as "pre
+// <path>" and each Post as "post <path>". Visits of values with a nil on the
+// way or cycles are marked.
+func record(t *testing.T, w *Walker, value interface{}, pre func(f *Field) error) []string {
+	t.Helper()
+	var events []string
+	name := func(f *Field) string {
+		s := f.Path
+		if f.Cycle {
+			s += " (cycle)"
+		} else if !f.Elem().IsValid() {
+			s += " (nil)"
+		}
+		return s
+	}
+	err := w.Walk(value, Funcs{
+		PreFunc: func(f *Field) error {
+			events = append(events, "pre "+name(f))
+			if pre != nil {
+				return pre(f)
+			}
+			return nil
+		},
+		PostFunc: func(f *Field) error {
+			events = append(events, "post "+name(f))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// pres keeps the paths of the Pre events.
+func pres(events []string) []string {
+	var paths []string
+	for _, e := range events {
+		if strings.HasPrefix(e, "pre ") {
+			paths = append(paths, strings.TrimPrefix(e, "pre "))
+		}
+	}
+	return paths
+}
+
+func TestPaths(t *testing.T) {
+	value := tree{
+		P:   []leaf{{}, {}, {}, {D: 3}},
+		W:   map[string]leaf{"k": {}},
+		M:   map[int]string{2: "b", 1: "a"},
+		Ptr: &leaf{},
+	}
+	want := []string{
+		"",
+		"N", "N.D",
+		"P", "P[0]", "P[0].D", "P[1]", "P[1].D", "P[2]", "P[2].D", "P[3]", "P[3].D",
+		"W", `W["k"]`, `W["k"].D`,
+		"M", "M[1]", "M[2]",
+		"I (nil)",
+		"Ptr", "Ptr.D",
+	}
+	if got := pres(record(t, &Walker{}, value, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	// Where each value was found
+	err := Walk(value, Funcs{PreFunc: func(f *Field) error {
+		switch f.Path {
+		case "P[3].D":
+			if f.Kind != StructField || f.Name() != "D" || f.Depth != 3 || f.Parent.Kind != Element || f.Parent.Index != 3 || f.Value.Int() != 3 {
+				t.Errorf("P[3].D is %+v", f)
+			}
+		case `W["k"]`:
+			if f.Kind != MapEntry || f.Key.String() != "k" || f.Name() != "" || f.Parent.Path != "W" {
+				t.Errorf(`W["k"] is %+v`, f)
+			}
+		case "":
+			if f.Kind != Root || f.Parent != nil || f.Depth != 0 {
+				t.Errorf("the root is %+v", f)
+			}
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	value := struct {
+		A leaf
+		B int
+	}{}
+	want := []string{"pre ", "pre A", "pre A.D", "post A.D", "post A", "pre B", "post B", "post "}
+	if got := record(t, &Walker{}, value, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// SkipChildren skips the Post too
+	skip := func(f *Field) error {
+		if f.Path == "A" {
+			return SkipChildren
+		}
+		return nil
+	}
+	want = []string{"pre ", "pre A", "pre B", "post B", "post "}
+	if got := record(t, &Walker{}, value, skip); !reflect.DeepEqual(got, want) {
+		t.Errorf("skipping A got %q, want %q", got, want)
+	}
+
+	// Stop ends the walk, and Walk returns nil
+	stop := func(f *Field) error {
+		if f.Path == "A.D" {
+			return Stop
+		}
+		return nil
+	}
+	want = []string{"pre ", "pre A", "pre A.D"}
+	if got := record(t, &Walker{}, value, stop); !reflect.DeepEqual(got, want) {
+		t.Errorf("stopping at A.D got %q, want %q", got, want)
+	}
+	err := Walk(value, Funcs{PostFunc: func(f *Field) error { return Stop }})
+	if err != nil {
+		t.Errorf("stopping from Post returned %v", err)
+	}
+
+	// Other errors end it with the error
+	boom := errors.New("boom")
+	err = Walk(value, Funcs{PreFunc: func(f *Field) error {
+		if f.Path == "B" {
+			return boom
+		}
+		return nil
+	}})
+	if err != boom {
+		t.Errorf("got %v, want %v", err, boom)
+	}
+}
+
+type node struct {
+	V    int
+	Next *node
+	Kids []*node
+}
+
+func TestCycles(t *testing.T) {
+	n := &node{V: 1}
+	n.Next = n
+	want := []string{"", "V", "Next (cycle)", "Kids"}
+	if got := pres(record(t, &Walker{}, n, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("pointer to itself got %q, want %q", got, want)
+	}
+
+	// Further down, and through a slice
+	a := &node{V: 1}
+	b := &node{V: 2, Next: a}
+	a.Kids = []*node{b}
+	want = []string{"", "V", "Next (nil)", "Kids", "Kids[0]", "Kids[0].V", "Kids[0].Next (cycle)", "Kids[0].Kids"}
+	if got := pres(record(t, &Walker{}, a, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("a -> b -> a got %q, want %q", got, want)
+	}
+
+	// A value seen twice, but not inside itself, isn't a cycle
+	shared := &leaf{}
+	twice := struct{ A, B *leaf }{shared, shared}
+	want = []string{"", "A", "A.D", "B", "B.D"}
+	if got := pres(record(t, &Walker{}, twice, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("shared pointer got %q, want %q", got, want)
+	}
+
+	m := map[string]interface{}{"x": 1}
+	m["self"] = m
+	want = []string{"", `["self"] (cycle)`, `["x"]`}
+	if got := pres(record(t, &Walker{}, m, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("map holding itself got %q, want %q", got, want)
+	}
+
+	s := []interface{}{nil, 2}
+	s[0] = s
+	want = []string{"", "[0] (cycle)", "[1]"}
+	if got := pres(record(t, &Walker{}, s, nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("slice holding itself got %q, want %q", got, want)
+	}
+}
+
+func TestNil(t *testing.T) {
+	value := struct {
+		I interface{}
+		P *leaf
+		J interface{}
+	}{J: (*leaf)(nil)}
+	want := []string{"pre ", "pre I (nil)", "post I (nil)", "pre P (nil)", "post P (nil)", "pre J (nil)", "post J (nil)", "post "}
+	if got := record(t, &Walker{}, value, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := record(t, &Walker{}, nil, nil); !reflect.DeepEqual(got, []string{"pre  (nil)", "post  (nil)"}) {
+		t.Errorf("walking nil got %q", got)
+	}
+}
+
+func TestSettable(t *testing.T) {
+	value := struct {
+		A int
+		P *leaf
+		L []int
+		M map[string]int
+	}{P: &leaf{}, L: []int{0}, M: map[string]int{"k": 0}}
+	set := Funcs{PreFunc: func(f *Field) error {
+		if v := f.Elem(); v.Kind() == reflect.Int && v.CanSet() {
+			v.SetInt(7)
+		}
+		return nil
+	}}
+
+	if err := Walk(value, set); err != nil {
+		t.Fatal(err)
+	}
+	if value.A != 0 {
+		t.Error("walking a struct, not a pointer to it, set its field")
+	}
+
+	if err := Walk(&value, set); err != nil {
+		t.Fatal(err)
+	}
+	if value.A != 7 || value.P.D != 7 || value.L[0] != 7 {
+		t.Errorf("walking a pointer didn't set the fields: %+v, P.D = %d", value, value.P.D)
+	}
+	if value.M["k"] != 0 {
+		t.Error("a map entry was set")
+	}
+}
+
+func TestTags(t *testing.T) {
+	value := struct {
+		A int `t:"x,y=1"`
+		B int `t:"z"`
+		c int
+	}{}
+	var got []string
+	err := (&Walker{Tag: "t"}).Walk(value, Funcs{PreFunc: func(f *Field) error {
+		if f.Kind == StructField {
+			got = append(got, fmt.Sprintf("%s %v", f.Path, f.Tag))
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A [{x  false} {y 1 true}]", "B [{z  false}]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	paths := pres(record(t, &Walker{Unexported: true}, value, nil))
+	if want := []string{"", "A", "B", "c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("with Unexported got %q, want %q", paths, want)
+	}
+
+	err = (&Walker{Tag: "t", Schema: tagopts.Schema{"x": {Type: tagopts.Flag}}}).Walk(value, Funcs{})
+	var fieldErr *tagopts.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != "A" {
+		t.Errorf("got %v, want a *tagopts.FieldError for A", err)
+	}
+}
+
+// order is a sample type with a few levels of tagged structs
+type order struct {
+	ID       string     `customtag:"label='Order ID',hello"`
+	Customer customer   `customtag:"decorateme"`
+	Lines    []line     `customtag:"world"`
+	Notes    string     `customtag:"noscan"`
+	Shipping *address   `customtag:"label='Ship to'"`
+	Tags     []string   `customtag:"idonothing"`
+	Extra    attributes `customtag:"hello,world"`
+}
+
+type customer struct {
+	Name    string  `customtag:"hello"`
+	Email   string  `customtag:"label='E-mail, work'"`
+	Address address `customtag:"decorateme"`
+}
+
+type address struct {
+	Street  string `customtag:"hello"`
+	City    string `customtag:"world"`
+	Country string `customtag:"idonothing"`
+}
+
+type line struct {
+	SKU      string  `customtag:"label='SKU'"`
+	Quantity int     `customtag:"hello"`
+	Price    float64 `customtag:"world"`
+}
+
+type attributes struct {
+	Gift    bool   `customtag:"hello"`
+	Channel string `customtag:"world"`
+}
+
+var schema = tagopts.Schema{
+	"noscan":     {Type: tagopts.Flag},
+	"world":      {Type: tagopts.Flag},
+	"hello":      {Type: tagopts.Flag},
+	"decorateme": {Type: tagopts.Flag},
+	"idonothing": {Type: tagopts.Flag},
+	"label":      {Type: tagopts.String},
+}
+
+func sampleOrder() order {
+	shipTo := address{"1 Main St", "Springfield", "US"}
+	o := order{
+		ID:       "o-1",
+		Customer: customer{"Jo", "jo@example.com", shipTo},
+		Shipping: &shipTo,
+		Tags:     []string{"new", "priority"},
+	}
+	for i := 0; i < 10; i++ {
+		o.Lines = append(o.Lines, line{fmt.Sprintf("sku-%d", i), i, float64(i) * 1.5})
+	}
+	return o
+}
+
+func nop(f *Field) error { return nil }
+
+// BenchmarkWalkNewWalker looks at every struct type and parses every tag
+// again on each walk.
+func BenchmarkWalkNewWalker(b *testing.B) {
+	o := sampleOrder()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := &Walker{Tag: "customtag", Schema: schema}
+		if err := w.Walk(o, Funcs{PreFunc: nop}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWalkReusedWalker finds the types in the Walker's cache.
+func BenchmarkWalkReusedWalker(b *testing.B) {
+	o := sampleOrder()
+	w := &Walker{Tag: "customtag", Schema: schema}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := w.Walk(o, Funcs{PreFunc: nop}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Package tagopts parses the options of a struct tag, like the
+// `noscan,max=64,oneof='a,b'` in `customtag:"noscan,max=64,oneof='a,b'"`,
+// and checks them against a Schema of the options a tag may have.
+//
+// Options are separated by commas. An option is either a flag, a bare word
+// like noscan, or a key=value pair. Keys can't hold spaces. Values run up to
+// the next comma, and spaces around keys and values are dropped. A value in
+// single quotes can hold commas and spaces, and a \' or \\ inside it stands for
+// ' or \. Keys may be repeated, and every value is kept in order.
+package tagopts
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Option is a single option of a tag.
+type Option struct {
+	Key   string
+	Value string
+	// HasValue is false for flags and true for key=value pairs, even if the
+	// value is empty.
+	HasValue bool
+}
+
+// Options are the options of a tag, in the order they're written.
+type Options []Option
+
+// Has reports whether key is set, as a flag or with a value.
+func (o Options) Has(key string) bool {
+	_, ok := o.Lookup(key)
+	return ok
+}
+
+// Lookup returns the value of the first option named key, and whether there
+// is one.
+func (o Options) Lookup(key string) (string, bool) {
+	for _, option := range o {
+		if option.Key == key {
+			return option.Value, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the value of the first option named key, or "" if there's none.
+func (o Options) Get(key string) string {
+	value, _ := o.Lookup(key)
+	return value
+}
+
+// All returns the values of every option named key.
+func (o Options) All(key string) []string {
+	var values []string
+	for _, option := range o {
+		if option.Key == key {
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// Int returns the value of key as an int. It returns 0 if key isn't set.
+func (o Options) Int(key string) (int, error) {
+	value, ok := o.Lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &OptionError{Key: key, Reason: fmt.Sprintf("%q isn't an int", value)}
+	}
+	return n, nil
+}
+
+// Float returns the value of key as a float64. It returns 0 if key isn't set.
+func (o Options) Float(key string) (float64, error) {
+	value, ok := o.Lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, &OptionError{Key: key, Reason: fmt.Sprintf("%q isn't a number", value)}
+	}
+	return f, nil
+}
+
+// Bool returns the value of key as a bool. A flag counts as true, and false
+// is returned if key isn't set.
+func (o Options) Bool(key string) (bool, error) {
+	for _, option := range o {
+		if option.Key != key {
+			continue
+		}
+		if !option.HasValue {
+			return true, nil
+		}
+		b, err := strconv.ParseBool(option.Value)
+		if err != nil {
+			return false, &OptionError{Key: key, Reason: fmt.Sprintf("%q isn't a bool", option.Value)}
+		}
+		return b, nil
+	}
+	return false, nil
+}
+
+// Duration returns the value of key as a time.Duration, like "1m30s". It
+// returns 0 if key isn't set.
+func (o Options) Duration(key string) (time.Duration, error) {
+	value, ok := o.Lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, &OptionError{Key: key, Reason: fmt.Sprintf("%q isn't a duration", value)}
+	}
+	return d, nil
+}
+
+// SyntaxError is returned by Parse for a tag it can't read.
+type SyntaxError struct {
+	// Offset is the byte offset in the tag where the error is.
+	Offset int
+	Reason string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("tagopts: %s at offset %d", e.Reason, e.Offset)
+}
+
+// Parse parses the options of a tag. Empty options, like the one in "a,,b",
+// are skipped.
+func Parse(tag string) (Options, error) {
+	var options Options
+	i := 0
+	for i <= len(tag) {
+		// Find the key, which ends at an = or a comma
+		start := i
+		for i < len(tag) && tag[i] != '=' && tag[i] != ',' {
+			if tag[i] == '\'' {
+				return nil, &SyntaxError{Offset: i, Reason: "quote in option name"}
+			}
+			i++
+		}
+		key := strings.TrimSpace(tag[start:i])
+		if j := strings.IndexFunc(key, unicode.IsSpace); j >= 0 {
+			offset := start + strings.Index(tag[start:i], key) + j
+			return nil, &SyntaxError{Offset: offset, Reason: "space in option name"}
+		}
+		if i == len(tag) || tag[i] == ',' {
+			if key != "" {
+				options = append(options, Option{Key: key})
+			}
+			i++
+			continue
+		}
+		if key == "" {
+			return nil, &SyntaxError{Offset: start, Reason: "value without an option name"}
+		}
+
+		// Find the value after the =
+		i++
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		var value string
+		if i < len(tag) && tag[i] == '\'' {
+			quote := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(tag) {
+					return nil, &SyntaxError{Offset: quote, Reason: "unterminated quote"}
+				}
+				if tag[i] == '\'' {
+					break
+				}
+				if tag[i] == '\\' {
+					if i+1 == len(tag) || (tag[i+1] != '\'' && tag[i+1] != '\\') {
+						return nil, &SyntaxError{Offset: i, Reason: `backslash not followed by ' or \`}
+					}
+					i++
+				}
+				b.WriteByte(tag[i])
+			}
+			value = b.String()
+			for i++; i < len(tag) && tag[i] == ' '; i++ {
+			}
+			if i < len(tag) && tag[i] != ',' {
+				return nil, &SyntaxError{Offset: i, Reason: "text after a quoted value"}
+			}
+		} else {
+			start := i
+			for i < len(tag) && tag[i] != ',' {
+				i++
+			}
+			value = strings.TrimSpace(tag[start:i])
+		}
+		options = append(options, Option{Key: key, Value: value, HasValue: true})
+		i++
+	}
+	return options, nil
+}
+
+// Type is the type of the value of an option.
+type Type int
+
+const (
+	// Flag options have no value.
+	Flag Type = iota
+	// String options have any value.
+	String
+	// Int options have an integer value.
+	Int
+	// Float options have a number value.
+	Float
+	// Bool options are flags, or have a value like "true" or "false".
+	Bool
+	// Duration options have a value like "1m30s".
+	Duration
+)
+
+var typeNames = [...]string{"flag", "string", "int", "float", "bool", "duration"}
+
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeNames) {
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+	return typeNames[t]
+}
+
+// Spec describes an option of a Schema.
+type Spec struct {
+	Type Type
+	// Repeated allows the option more than once.
+	Repeated bool
+	// Required makes the option mandatory. ParseField only asks for it in
+	// fields that have the tag.
+	Required bool
+}
+
+// Schema lists the options a tag may have, by key.
+type Schema map[string]Spec
+
+// OptionError is an option that doesn't fit its Schema or can't be converted
+// to its type.
+type OptionError struct {
+	Key    string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("tagopts: option %s: %s", e.Key, e.Reason)
+}
+
+// Validate checks that options only has the options of s, with values of the
+// right types, that only repeated options are repeated and that every
+// required option is there. It returns an *OptionError for the first option
+// that doesn't fit.
+func (s Schema) Validate(options Options) error {
+	seen := make(map[string]bool)
+	for _, option := range options {
+		spec, ok := s[option.Key]
+		if !ok {
+			return &OptionError{Key: option.Key, Reason: "unknown option"}
+		}
+		if seen[option.Key] && !spec.Repeated {
+			return &OptionError{Key: option.Key, Reason: "set more than once"}
+		}
+		seen[option.Key] = true
+		if spec.Type == Flag || spec.Type == Bool {
+			if option.HasValue && spec.Type == Flag {
+				return &OptionError{Key: option.Key, Reason: "is a flag and can't have a value"}
+			}
+		} else if !option.HasValue {
+			return &OptionError{Key: option.Key, Reason: fmt.Sprintf("needs a value of type %s", spec.Type)}
+		}
+		// Check the value the way the accessors read it
+		single := Options{option}
+		var err error
+		switch spec.Type {
+		case Int:
+			_, err = single.Int(option.Key)
+		case Float:
+			_, err = single.Float(option.Key)
+		case Bool:
+			_, err = single.Bool(option.Key)
+		case Duration:
+			_, err = single.Duration(option.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var keys []string
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if s[key].Required && !seen[key] {
+			return &OptionError{Key: key, Reason: "is required"}
+		}
+	}
+	return nil
+}
+
+// Parse parses tag and validates it against s.
+func (s Schema) Parse(tag string) (Options, error) {
+	options, err := Parse(tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Validate(options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// FieldError is an error in the tag of a struct field.
+type FieldError struct {
+	// Type is the struct the field belongs to.
+	Type reflect.Type
+	// Field is the name of the field.
+	Field string
+	// Tag is the tag, as it's written, like `customtag:"max=x"`.
+	Tag string
+	// Err is a *SyntaxError or an *OptionError.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("tagopts: field %s.%s, tag %s: %s", e.Type, e.Field, e.Tag,
+		strings.TrimPrefix(e.Err.Error(), "tagopts: "))
+}
+
+// Unwrap returns the error of the tag.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ParseField parses the tag named name of a field of the struct t, and
+// validates it against schema if it's not nil. Errors are *FieldErrors. A
+// field without the tag has no options and isn't validated, so required
+// options are only required of fields that have the tag, even if it's empty.
+func ParseField(t reflect.Type, field reflect.StructField, name string, schema Schema) (Options, error) {
+	if name == "" {
+		return nil, errors.New("tagopts: no tag name")
+	}
+	tag, ok := field.Tag.Lookup(name)
+	if !ok {
+		return nil, nil
+	}
+	options, err := Parse(tag)
+	if err == nil && schema != nil {
+		err = schema.Validate(options)
+	}
+	if err != nil {
+		return nil, &FieldError{Type: t, Field: field.Name, Tag: name + ":" + strconv.Quote(tag), Err: err}
+	}
+	return options, nil
+}
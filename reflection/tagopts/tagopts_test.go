@@ -0,0 +1,223 @@
+package tagopts
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want Options
+	}{
+		{"", nil},
+		{"a", Options{{Key: "a"}}},
+		{"a,,b", Options{{Key: "a"}, {Key: "b"}}},
+		{",a,", Options{{Key: "a"}}},
+		{" a , b ", Options{{Key: "a"}, {Key: "b"}}},
+		{"max=64", Options{{Key: "max", Value: "64", HasValue: true}}},
+		{"k=", Options{{Key: "k", HasValue: true}}},
+		{"k = v ,x", Options{{Key: "k", Value: "v", HasValue: true}, {Key: "x"}}},
+		{"k=a=b", Options{{Key: "k", Value: "a=b", HasValue: true}}},
+		{"k=1,k=2", Options{{Key: "k", Value: "1", HasValue: true}, {Key: "k", Value: "2", HasValue: true}}},
+		{"label='Hi, there'", Options{{Key: "label", Value: "Hi, there", HasValue: true}}},
+		{"k='', x", Options{{Key: "k", HasValue: true}, {Key: "x"}}},
+		{"k= ' a '  ,x", Options{{Key: "k", Value: " a ", HasValue: true}, {Key: "x"}}},
+		{`a='it\'s',b='c:\\'`, Options{{Key: "a", Value: "it's", HasValue: true}, {Key: "b", Value: `c:\`, HasValue: true}}},
+		{"k=it's", Options{{Key: "k", Value: "it's", HasValue: true}}},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.tag)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.tag, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	tests := []struct {
+		tag    string
+		offset int
+		reason string
+	}{
+		{"a'b", 1, "quote in option name"},
+		{"=v", 0, "value without an option name"},
+		{"a, =v", 2, "value without an option name"},
+		{"k='abc", 2, "unterminated quote"},
+		{`k='a\b'`, 4, `backslash not followed by ' or \`},
+		{`k='a\`, 4, `backslash not followed by ' or \`},
+		{"k='a'b", 5, "text after a quoted value"},
+		{"k='a' b", 6, "text after a quoted value"},
+		{"a b", 1, "space in option name"},
+		{"a b=c", 1, "space in option name"},
+		{"ok, x\ty=c", 5, "space in option name"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.tag)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) returned %v, want a *SyntaxError", test.tag, err)
+			continue
+		}
+		if syntaxErr.Offset != test.offset || syntaxErr.Reason != test.reason {
+			t.Errorf("Parse(%q) failed with %q at %d, want %q at %d", test.tag, syntaxErr.Reason, syntaxErr.Offset, test.reason, test.offset)
+		}
+	}
+
+	_, err := Parse("a b")
+	if want := "tagopts: space in option name at offset 1"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := Schema{
+		"flag": {Type: Flag},
+		"name": {Type: String},
+		"n":    {Type: Int},
+		"f":    {Type: Float},
+		"b":    {Type: Bool},
+		"d":    {Type: Duration},
+		"tag":  {Type: String, Repeated: true},
+	}
+	tests := []struct {
+		tag, key, reason string
+	}{
+		{"", "", ""},
+		{"flag,name=x,n=3,f=1.5,b,d=1m30s,tag=a,tag=b", "", ""},
+		{"b=false,name=", "", ""},
+		{"nope", "nope", "unknown option"},
+		{"name=a,name=b", "name", "set more than once"},
+		{"flag,flag", "flag", "set more than once"},
+		{"flag=1", "flag", "is a flag and can't have a value"},
+		{"name", "name", "needs a value of type string"},
+		{"n", "n", "needs a value of type int"},
+		{"d", "d", "needs a value of type duration"},
+		{"n=x", "n", `"x" isn't an int`},
+		{"f=x", "f", `"x" isn't a number`},
+		{"b=maybe", "b", `"maybe" isn't a bool`},
+		{"d=soon", "d", `"soon" isn't a duration`},
+	}
+	for _, test := range tests {
+		options, err := Parse(test.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = schema.Validate(options)
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("Validate(%q): %v", test.tag, err)
+			}
+			continue
+		}
+		optionErr, ok := err.(*OptionError)
+		if !ok || optionErr.Key != test.key || optionErr.Reason != test.reason {
+			t.Errorf("Validate(%q) returned %v, want option %s: %s", test.tag, err, test.key, test.reason)
+		}
+	}
+
+	required := Schema{"b": {Type: Flag, Required: true}, "a": {Type: String, Required: true}}
+	if _, err := required.Parse("b"); err == nil || err.Error() != "tagopts: option a: is required" {
+		t.Errorf("missing a required option got %v", err)
+	}
+	// The first one missing is reported, in order of their keys
+	if _, err := required.Parse(""); err == nil || err.Error() != "tagopts: option a: is required" {
+		t.Errorf("missing both required options got %v", err)
+	}
+	if _, err := required.Parse("a=x,b"); err != nil {
+		t.Errorf("required options set: %v", err)
+	}
+	if _, err := required.Parse("a='x"); err == nil {
+		t.Error("Schema.Parse took a bad tag")
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	options, err := Parse("n=3,f=1.5,b,c=false,d=1m,s=x,s=y,bad=z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := options.Int("n"); n != 3 || err != nil {
+		t.Errorf("Int = %d, %v", n, err)
+	}
+	if f, err := options.Float("f"); f != 1.5 || err != nil {
+		t.Errorf("Float = %v, %v", f, err)
+	}
+	if b, err := options.Bool("b"); !b || err != nil {
+		t.Errorf("Bool of a flag = %v, %v", b, err)
+	}
+	if c, err := options.Bool("c"); c || err != nil {
+		t.Errorf("Bool = %v, %v", c, err)
+	}
+	if d, err := options.Duration("d"); d != time.Minute || err != nil {
+		t.Errorf("Duration = %v, %v", d, err)
+	}
+	if s := options.Get("s"); s != "x" {
+		t.Errorf("Get = %q", s)
+	}
+	if all := options.All("s"); !reflect.DeepEqual(all, []string{"x", "y"}) {
+		t.Errorf("All = %q", all)
+	}
+	if n, err := options.Int("missing"); n != 0 || err != nil {
+		t.Errorf("Int of a missing option = %d, %v", n, err)
+	}
+	if _, err := options.Int("bad"); err == nil || err.Error() != `tagopts: option bad: "z" isn't an int` {
+		t.Errorf("Int of a bad value got %v", err)
+	}
+}
+
+type tagged struct {
+	X string `t:"name=x"`
+	Y string
+	Z string `t:""`
+	W string `t:"name='x"`
+}
+
+func TestParseField(t *testing.T) {
+	schema := Schema{"name": {Type: String, Required: true}}
+	typ := reflect.TypeOf(tagged{})
+	field := func(name string) reflect.StructField {
+		f, _ := typ.FieldByName(name)
+		return f
+	}
+
+	options, err := ParseField(typ, field("X"), "t", schema)
+	if err != nil || options.Get("name") != "x" {
+		t.Errorf("X got %v, %v", options, err)
+	}
+	// Without the tag there's nothing to check
+	if options, err := ParseField(typ, field("Y"), "t", schema); options != nil || err != nil {
+		t.Errorf("Y got %v, %v", options, err)
+	}
+
+	_, err = ParseField(typ, field("Z"), "t", schema)
+	if want := `tagopts: field tagopts.tagged.Z, tag t:"": option name: is required`; err == nil || err.Error() != want {
+		t.Errorf("Z got %v, want %s", err, want)
+	}
+	var optionErr *OptionError
+	if !errors.As(err, &optionErr) {
+		t.Errorf("Z got %v, want an *OptionError inside", err)
+	}
+
+	_, err = ParseField(typ, field("W"), "t", nil)
+	var syntaxErr *SyntaxError
+	if fieldErr, ok := err.(*FieldError); !ok || fieldErr.Field != "W" || !errors.As(err, &syntaxErr) {
+		t.Errorf("W got %v, want a *FieldError with a *SyntaxError", err)
+	}
+
+	if _, err := ParseField(typ, field("X"), "", nil); err == nil {
+		t.Error("ParseField took an empty tag name")
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	if Duration.String() != "duration" || Type(9).String() != "Type(9)" {
+		t.Errorf("got %s and %s", Duration, Type(9))
+	}
+}
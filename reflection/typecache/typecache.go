@@ -0,0 +1,104 @@
+// Package typecache keeps what reflection learns about struct types, so it's
+// worked out once per type instead of once per value: which fields to visit,
+// their indexes and their parsed struct tags. Reading a field from a value is
+// then just reflect.Value.Field with the cached index.
+package typecache
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+)
+
+// Field is what's known about a field of a struct type.
+type Field struct {
+	// Name is the name of the field in Go.
+	Name string
+	// Index is the index of the field in its struct, for reflect.Value.Field.
+	Index int
+	// StructField is the field as reflect describes it.
+	StructField reflect.StructField
+	// Tag holds the options of the struct tag named by Cache.Tag.
+	Tag tagopts.Options
+	// Kind is the kind of the field's type, so leaves can be told apart from
+	// values with children without looking at the type again.
+	Kind reflect.Kind
+}
+
+// Value returns the field of v, a struct of the type the field belongs to.
+func (f *Field) Value(v reflect.Value) reflect.Value {
+	return v.Field(f.Index)
+}
+
+// Struct is what's known about a struct type.
+type Struct struct {
+	Type reflect.Type
+	// Fields are the fields to visit, in declaration order.
+	Fields []Field
+	byName map[string]int
+}
+
+// Field returns the field called name, or nil if there's none or it isn't
+// visited.
+func (s *Struct) Field(name string) *Field {
+	i, ok := s.byName[name]
+	if !ok {
+		return nil
+	}
+	return &s.Fields[i]
+}
+
+// Cache holds a Struct for each struct type it's asked about. Its settings
+// must not change once it's in use. A Cache is safe for concurrent use, and
+// must not be copied after first use.
+type Cache struct {
+	// Tag is the name of the struct tag to parse into Field.Tag, if any.
+	Tag string
+	// Schema, if set, is checked against the tag of every field.
+	Schema tagopts.Schema
+	// Unexported includes unexported fields.
+	Unexported bool
+
+	types sync.Map // reflect.Type -> *entry
+}
+
+type entry struct {
+	s   *Struct
+	err error
+}
+
+// Struct returns what's known about the struct type t, working it out the
+// first time t is seen. A tag that can't be parsed or doesn't fit the
+// Schema is a *tagopts.FieldError, which is cached too.
+func (c *Cache) Struct(t reflect.Type) (*Struct, error) {
+	if e, ok := c.types.Load(t); ok {
+		return e.(*entry).s, e.(*entry).err
+	}
+	s, err := c.Build(t)
+	// Another goroutine may have got there first, and its result is as good
+	e, _ := c.types.LoadOrStore(t, &entry{s, err})
+	return e.(*entry).s, e.(*entry).err
+}
+
+// Build works out what's known about the struct type t without caching it.
+// It panics if t isn't a struct.
+func (c *Cache) Build(t reflect.Type) (*Struct, error) {
+	s := &Struct{Type: t, byName: make(map[string]int)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !c.Unexported {
+			continue
+		}
+		f := Field{Name: sf.Name, Index: i, StructField: sf, Kind: sf.Type.Kind()}
+		if c.Tag != "" {
+			var err error
+			if f.Tag, err = tagopts.ParseField(t, sf, c.Tag, c.Schema); err != nil {
+				return nil, err
+			}
+		}
+		s.byName[sf.Name] = len(s.Fields)
+		s.Fields = append(s.Fields, f)
+	}
+	return s, nil
+}
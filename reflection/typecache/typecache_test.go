@@ -0,0 +1,147 @@
+package typecache
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+)
+
+type mixed struct {
+	a int
+	B string `t:"x,y=1"`
+	c bool
+	D int `t:"z"`
+}
+
+func names(s *Struct) []string {
+	var names []string
+	for _, f := range s.Fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestFields(t *testing.T) {
+	typ := reflect.TypeOf(mixed{})
+	s, err := (&Cache{Tag: "t"}).Struct(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != typ {
+		t.Errorf("Type is %v", s.Type)
+	}
+	if got := names(s); !reflect.DeepEqual(got, []string{"B", "D"}) {
+		t.Errorf("fields are %q, want the exported B and D", got)
+	}
+
+	d := s.Field("D")
+	if d == nil || d.Index != 3 || d.Kind != reflect.Int || d.StructField.Name != "D" || !d.Tag.Has("z") {
+		t.Fatalf("D is %+v", d)
+	}
+	if got := d.Value(reflect.ValueOf(mixed{D: 7})).Int(); got != 7 {
+		t.Errorf("reading D got %d", got)
+	}
+	b := s.Field("B")
+	if b == nil || b.Index != 1 || b.Tag.Get("y") != "1" {
+		t.Errorf("B is %+v", b)
+	}
+	if s.Field("a") != nil || s.Field("nope") != nil {
+		t.Error("Field found a field that isn't visited")
+	}
+
+	s, err = (&Cache{Unexported: true}).Struct(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(s); !reflect.DeepEqual(got, []string{"a", "B", "c", "D"}) {
+		t.Errorf("with Unexported, fields are %q", got)
+	}
+	if c := s.Field("c"); c == nil || c.Index != 2 || c.Tag != nil {
+		t.Errorf("c is %+v, want index 2 and no tag parsed", c)
+	}
+}
+
+func TestCaching(t *testing.T) {
+	cache := &Cache{Tag: "t"}
+	typ := reflect.TypeOf(mixed{})
+	first, _ := cache.Struct(typ)
+	second, _ := cache.Struct(typ)
+	if first != second {
+		t.Error("Struct worked a type out twice")
+	}
+	if built, _ := cache.Build(typ); built == first {
+		t.Error("Build returned the cached Struct")
+	}
+
+	// Results are the same from many goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, _ := cache.Struct(typ); s != first {
+				t.Error("a goroutine got another Struct")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestErrorsAreCached(t *testing.T) {
+	type bad struct {
+		A int `t:"x=1"`
+	}
+	cache := &Cache{Tag: "t", Schema: tagopts.Schema{"x": {Type: tagopts.Flag}}}
+	typ := reflect.TypeOf(bad{})
+	s, err := cache.Struct(typ)
+	var fieldErr *tagopts.FieldError
+	if s != nil || !errors.As(err, &fieldErr) || fieldErr.Field != "A" {
+		t.Fatalf("got %v, %v, want a *tagopts.FieldError for A", s, err)
+	}
+	if _, again := cache.Struct(typ); again != err {
+		t.Errorf("second lookup returned %v, not the cached error", again)
+	}
+}
+
+// sample is a struct with a few tagged fields, for the benchmarks.
+type sample struct {
+	ID    string   `t:"label='ID',hello"`
+	Name  string   `t:"hello"`
+	Email string   `t:"label='E-mail, work'"`
+	Price float64  `t:"world"`
+	Tags  []string `t:"hello,world"`
+	note  string
+}
+
+var sampleSchema = tagopts.Schema{
+	"hello": {Type: tagopts.Flag},
+	"world": {Type: tagopts.Flag},
+	"label": {Type: tagopts.String},
+}
+
+// BenchmarkBuild inspects the type and parses its tags every time.
+func BenchmarkBuild(b *testing.B) {
+	cache := &Cache{Tag: "t", Schema: sampleSchema}
+	t := reflect.TypeOf(sample{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Build(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStruct looks the type up in the cache.
+func BenchmarkStruct(b *testing.B) {
+	cache := &Cache{Tag: "t", Schema: sampleSchema}
+	t := reflect.TypeOf(sample{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.Struct(t); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
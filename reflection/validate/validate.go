@@ -0,0 +1,451 @@
+// Package validate checks values against rules in their struct tags, like
+//
+//	type User struct {
+//		Name     string   `validate:"required,min=3,max=64"`
+//		Role     string   `validate:"oneof=admin editor viewer"`
+//		Email    string   `validate:"required,email"`
+//		Password string   `validate:"min=12"`
+//		Confirm  string   `validate:"eqfield=Password"`
+//		Tags     []string `validate:"max=5,dive,regexp='^[a-z]{2,16}$'"`
+//	}
+//
+// Rules are tagopts options, so values with commas go in single quotes.
+// Structs are checked all the way down: fields holding structs, pointers to
+// them and slices, arrays and maps of them are checked too. Rules after dive
+// apply to each element of a slice, array or map instead of to the field.
+//
+// A field that's empty (its zero value, an empty slice or map, or a nil
+// pointer) passes every rule but required, so optional fields only need rules
+// for when they're set. A pointer that's set isn't empty, whatever it points
+// to, and its rules check what it points to: `validate:"min=3"` on an *int
+// takes nil but not a pointer to 0.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wingedrhino/golang-snippets/reflection/structwalk"
+	"github.com/wingedrhino/golang-snippets/reflection/tagopts"
+)
+
+// Field is a value being checked by a Rule.
+type Field struct {
+	// Path is the path of the value, like "Users[2].Email".
+	Path string
+	// Value is the value, with pointers and interfaces followed. It's the
+	// zero Value if there's a nil on the way.
+	Value reflect.Value
+	// StructField is the struct field the rule is on. For rules after
+	// dive, that's the field holding the elements.
+	StructField reflect.StructField
+	// Struct is the struct StructField belongs to.
+	Struct reflect.Value
+
+	// stored is the value as it's stored, before following pointers.
+	stored reflect.Value
+}
+
+// Sibling returns the field called name of the struct f belongs to, with
+// pointers followed, for rules comparing fields. It returns the zero Value if
+// there's no such field.
+func (f *Field) Sibling(name string) reflect.Value {
+	v := f.Struct.FieldByName(name)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// Rule checks a field against the parameter of its option, like the "3" of
+// min=3, which is "" for flags. It returns a message saying what's wrong,
+// like "must be at least 3 characters long", or "" if the field is fine. An
+// error means the rule can't be used that way, like min=abc or min on a
+// bool, and stops the validation.
+type Rule func(f *Field, param string) (string, error)
+
+// FieldError is a rule a field breaks.
+type FieldError struct {
+	Path string
+	// Rule is the name of the rule and Param its parameter.
+	Rule  string
+	Param string
+	// Message says what's wrong with the field.
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return "validate: " + e.describe()
+}
+
+func (e *FieldError) describe() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// StructError is returned for a value that breaks rules. It lists every
+// rule broken rather than stopping at the first.
+type StructError struct {
+	Fields []*FieldError
+}
+
+func (e *StructError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.describe()
+	}
+	return fmt.Sprintf("validate: %d problem(s): %s", len(e.Fields), strings.Join(problems, "; "))
+}
+
+// Validator holds custom rules and the tag name to read rules from. Like a
+// structwalk.Walker, it caches what it learns about struct types, so reuse
+// one, and don't change its Tag or copy it after its first use. Rules may be
+// registered at any time. A Validator is safe for concurrent use.
+type Validator struct {
+	// Tag is the name of the struct tag holding the rules. It defaults to
+	// "validate".
+	Tag string
+
+	once   sync.Once
+	walker *structwalk.Walker
+	mu     sync.RWMutex
+	rules  map[string]Rule
+}
+
+var defaultValidator Validator
+
+// Struct checks v with the default Validator.
+func Struct(v interface{}) error {
+	return defaultValidator.Struct(v)
+}
+
+// Register adds a rule to the default Validator.
+func Register(name string, rule Rule) {
+	defaultValidator.Register(name, rule)
+}
+
+// Register adds a rule called name, which can then be used in tags. It
+// replaces any rule of that name, including the built in ones.
+func (v *Validator) Register(name string, rule Rule) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.rules == nil {
+		v.rules = make(map[string]Rule)
+	}
+	v.rules[name] = rule
+}
+
+func (v *Validator) rule(name string) Rule {
+	v.mu.RLock()
+	rule, ok := v.rules[name]
+	v.mu.RUnlock()
+	if ok {
+		return rule
+	}
+	return builtinRules[name]
+}
+
+// Struct checks the fields of v, which is usually a struct or a pointer to
+// one, and everything they hold. It returns a *StructError listing every
+// rule broken, or another error for rules that don't exist or can't be used
+// the way a tag uses them.
+func (v *Validator) Struct(value interface{}) error {
+	v.once.Do(func() {
+		tag := v.Tag
+		if tag == "" {
+			tag = "validate"
+		}
+		v.walker = &structwalk.Walker{Tag: tag}
+	})
+	var broken []*FieldError
+	err := v.walker.Walk(value, structwalk.Funcs{PreFunc: func(f *structwalk.Field) error {
+		var options tagopts.Options
+		var field *structwalk.Field
+		switch {
+		case f.Kind == structwalk.StructField:
+			if f.Tag.Has("-") {
+				return structwalk.SkipChildren
+			}
+			options, _ = split(f.Tag)
+			field = f
+		case f.Parent != nil && f.Parent.Kind == structwalk.StructField && (f.Kind == structwalk.Element || f.Kind == structwalk.MapEntry):
+			_, options = split(f.Parent.Tag)
+			field = f.Parent
+		}
+		if len(options) == 0 {
+			return nil
+		}
+		check := &Field{Path: f.Path, Value: f.Elem(), StructField: field.StructField, Struct: field.Parent.Elem(), stored: f.Value}
+		if !options.Has("required") && isEmpty(check.stored) {
+			return nil
+		}
+		for _, option := range options {
+			rule := v.rule(option.Key)
+			if rule == nil {
+				return fmt.Errorf("validate: %s: unknown rule %q", f.Path, option.Key)
+			}
+			message, err := rule(check, option.Value)
+			if err != nil {
+				return fmt.Errorf("validate: %s: rule %s: %v", f.Path, option.Key, err)
+			}
+			if message != "" {
+				broken = append(broken, &FieldError{Path: f.Path, Rule: option.Key, Param: option.Value, Message: message})
+				if option.Key == "required" {
+					// Nothing else applies to a missing value
+					break
+				}
+			}
+		}
+		return nil
+	}})
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		return &StructError{Fields: broken}
+	}
+	return nil
+}
+
+// split splits the options of a tag at dive, into the rules for the field
+// and the rules for its elements.
+func split(options tagopts.Options) (field, elements tagopts.Options) {
+	for i, option := range options {
+		if option.Key == "dive" {
+			return options[:i], options[i+1:]
+		}
+	}
+	return options, nil
+}
+
+// isEmpty reports whether v, a value as it's stored, is missing, zero or has a
+// length of zero. Pointers and interfaces are only empty if there's a nil on
+// the way, and not for what they point to.
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		if elem := v.Elem(); elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+			return isEmpty(elem)
+		}
+		return false
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+var builtinRules = map[string]Rule{
+	"required": required,
+	"min":      bound("min"),
+	"max":      bound("max"),
+	"oneof":    oneOf,
+	"email":    email,
+	"regexp":   match,
+	"eqfield":  compareField("eqfield", "must equal %s", func(c int) bool { return c == 0 }),
+	"nefield":  compareField("nefield", "must not equal %s", func(c int) bool { return c != 0 }),
+	"gtfield":  compareField("gtfield", "must be greater than %s", func(c int) bool { return c > 0 }),
+	"gtefield": compareField("gtefield", "must be at least %s", func(c int) bool { return c >= 0 }),
+	"ltfield":  compareField("ltfield", "must be less than %s", func(c int) bool { return c < 0 }),
+	"ltefield": compareField("ltefield", "must be at most %s", func(c int) bool { return c <= 0 }),
+}
+
+func required(f *Field, param string) (string, error) {
+	if isEmpty(f.stored) {
+		return "is required", nil
+	}
+	return "", nil
+}
+
+// bound returns min or max, which check the length of strings (in
+// characters), slices, arrays and maps, and the value of numbers.
+func bound(name string) Rule {
+	return func(f *Field, param string) (string, error) {
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q isn't a number", param)
+		}
+		var n float64
+		var unit string
+		switch f.Value.Kind() {
+		case reflect.String:
+			n, unit = float64(utf8.RuneCountInString(f.Value.String())), " characters long"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n, unit = float64(f.Value.Len()), " items long"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(f.Value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n = float64(f.Value.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = f.Value.Float()
+		default:
+			return "", fmt.Errorf("can't be used on %s", f.Value.Type())
+		}
+		if name == "min" && n < limit {
+			return fmt.Sprintf("must be at least %s%s", param, unit), nil
+		}
+		if name == "max" && n > limit {
+			return fmt.Sprintf("must be at most %s%s", param, unit), nil
+		}
+		return "", nil
+	}
+}
+
+// oneOf checks that a string, number or bool is one of a list of values
+// separated by spaces.
+func oneOf(f *Field, param string) (string, error) {
+	switch f.Value.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map, reflect.Func, reflect.Chan:
+		return "", fmt.Errorf("can't be used on %s", f.Value.Type())
+	}
+	values := strings.Fields(param)
+	s := fmt.Sprint(f.Value)
+	for _, value := range values {
+		if s == value {
+			return "", nil
+		}
+	}
+	return "must be one of " + strings.Join(values, ", "), nil
+}
+
+// email checks that a string is an email address, without a display name.
+func email(f *Field, param string) (string, error) {
+	if f.Value.Kind() != reflect.String {
+		return "", fmt.Errorf("can't be used on %s", f.Value.Type())
+	}
+	address, err := mail.ParseAddress(f.Value.String())
+	if err != nil || address.Address != f.Value.String() {
+		return "must be an email address", nil
+	}
+	return "", nil
+}
+
+// patterns caches compiled regular expressions by their source.
+var patterns sync.Map
+
+// match checks that a string matches a regular expression. It isn't
+// anchored, so use ^ and $ to match the whole string.
+func match(f *Field, param string) (string, error) {
+	if f.Value.Kind() != reflect.String {
+		return "", fmt.Errorf("can't be used on %s", f.Value.Type())
+	}
+	re, ok := patterns.Load(param)
+	if !ok {
+		compiled, err := regexp.Compile(param)
+		if err != nil {
+			return "", err
+		}
+		re, _ = patterns.LoadOrStore(param, compiled)
+	}
+	if !re.(*regexp.Regexp).MatchString(f.Value.String()) {
+		return "must match " + param, nil
+	}
+	return "", nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compareField returns a rule comparing a field with the sibling field named
+// by its parameter. ok is given -1, 0 or 1 as the field is less than, equal
+// to or greater than the other one.
+func compareField(name, message string, ok func(int) bool) Rule {
+	return func(f *Field, param string) (string, error) {
+		other := f.Sibling(param)
+		if !other.IsValid() {
+			if _, found := f.Struct.Type().FieldByName(param); !found {
+				return "", fmt.Errorf("no field %s", param)
+			}
+			// A nil pointer is only equal to a missing value
+			if name == "eqfield" || name == "nefield" {
+				if ok(compareEmpty(f.stored)) {
+					return "", nil
+				}
+				return fmt.Sprintf(message, param), nil
+			}
+			return "", nil
+		}
+		c, err := compare(f.Value, other)
+		if err != nil {
+			return "", err
+		}
+		if !ok(c) {
+			return fmt.Sprintf(message, param), nil
+		}
+		return "", nil
+	}
+}
+
+func compareEmpty(v reflect.Value) int {
+	if isEmpty(v) {
+		return 0
+	}
+	return 1
+}
+
+// compare compares two values of the same kind: numbers, strings and
+// time.Time can be ordered, and anything else can only be equal or not.
+func compare(a, b reflect.Value) (int, error) {
+	sign := func(less, greater bool) int {
+		switch {
+		case less:
+			return -1
+		case greater:
+			return 1
+		}
+		return 0
+	}
+	if a.Type() == timeType && b.Type() == timeType {
+		x, y := a.Interface().(time.Time), b.Interface().(time.Time)
+		return sign(x.Before(y), x.After(y)), nil
+	}
+	switch {
+	case isInt(a) && isInt(b):
+		return sign(a.Int() < b.Int(), a.Int() > b.Int()), nil
+	case isUint(a) && isUint(b):
+		return sign(a.Uint() < b.Uint(), a.Uint() > b.Uint()), nil
+	case isFloat(a) && isFloat(b):
+		return sign(a.Float() < b.Float(), a.Float() > b.Float()), nil
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return strings.Compare(a.String(), b.String()), nil
+	case a.Type() == b.Type():
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("can't compare %s with %s", a.Type(), b.Type())
+}
+
+func isInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isFloat(v reflect.Value) bool {
+	return v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
@@ -0,0 +1,315 @@
+package validate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func intPtr(n int) *int { return &n }
+
+// problems validates value with v and returns the broken rules as
+// "path rule".
+func problems(t *testing.T, v *Validator, value interface{}) []string {
+	t.Helper()
+	err := v.Struct(value)
+	if err == nil {
+		return nil
+	}
+	structErr, ok := err.(*StructError)
+	if !ok {
+		t.Fatalf("got %v, want a *StructError", err)
+	}
+	var got []string
+	for _, f := range structErr.Fields {
+		got = append(got, f.Path+" "+f.Rule)
+	}
+	return got
+}
+
+type user struct {
+	Email string `validate:"required,email"`
+}
+
+func TestRules(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []string
+	}{
+		{"required missing", struct {
+			S string `validate:"required"`
+		}{}, []string{"S required"}},
+		{"required set", struct {
+			S string `validate:"required"`
+		}{"a"}, nil},
+		{"required empty slice", struct {
+			L []int `validate:"required"`
+		}{[]int{}}, []string{"L required"}},
+		{"required nil pointer", struct {
+			P *int `validate:"required"`
+		}{}, []string{"P required"}},
+		{"required pointer to zero", struct {
+			P *int `validate:"required"`
+		}{intPtr(0)}, nil},
+		{"required stops other rules", struct {
+			S string `validate:"required,min=3"`
+		}{}, []string{"S required"}},
+
+		{"min characters", struct {
+			S string `validate:"min=3"`
+		}{"ab"}, []string{"S min"}},
+		{"min counts characters, not bytes", struct {
+			S string `validate:"min=3,max=3"`
+		}{"héé"}, nil},
+		{"max characters", struct {
+			S string `validate:"max=3"`
+		}{"abcd"}, []string{"S max"}},
+		{"min items", struct {
+			L []int `validate:"min=2"`
+		}{[]int{1}}, []string{"L min"}},
+		{"max map entries", struct {
+			M map[string]int `validate:"max=1"`
+		}{map[string]int{"a": 1, "b": 2}}, []string{"M max"}},
+		{"min int", struct {
+			N int `validate:"min=3"`
+		}{2}, []string{"N min"}},
+		{"max uint", struct {
+			N uint8 `validate:"max=3"`
+		}{4}, []string{"N max"}},
+		{"min float", struct {
+			F float64 `validate:"min=0.5"`
+		}{0.25}, []string{"F min"}},
+		{"zero skips min", struct {
+			N int `validate:"min=3"`
+		}{}, nil},
+		{"nil pointer skips min", struct {
+			N *int `validate:"min=3"`
+		}{}, nil},
+		{"pointer to zero checks min", struct {
+			N *int `validate:"min=3"`
+		}{intPtr(0)}, []string{"N min"}},
+		{"pointer checks min", struct {
+			N *int `validate:"min=3"`
+		}{intPtr(3)}, nil},
+		{"interface to zero checks min", struct {
+			I interface{} `validate:"min=3"`
+		}{0}, []string{"I min"}},
+
+		{"oneof string", struct {
+			S string `validate:"oneof=a b"`
+		}{"c"}, []string{"S oneof"}},
+		{"oneof string set", struct {
+			S string `validate:"oneof=a b"`
+		}{"b"}, nil},
+		{"oneof int", struct {
+			N int `validate:"oneof=1 2"`
+		}{3}, []string{"N oneof"}},
+
+		{"email", struct {
+			S string `validate:"email"`
+		}{"jo@example.com"}, nil},
+		{"email with a name", struct {
+			S string `validate:"email"`
+		}{"Jo <jo@example.com>"}, []string{"S email"}},
+		{"email without an at", struct {
+			S string `validate:"email"`
+		}{"jo"}, []string{"S email"}},
+
+		{"regexp", struct {
+			S string `validate:"regexp='^[a-z]{2,3}$'"`
+		}{"ab"}, nil},
+		{"regexp no match", struct {
+			S string `validate:"regexp='^[a-z]{2,3}$'"`
+		}{"abcd"}, []string{"S regexp"}},
+
+		{"eqfield", struct {
+			A string
+			B string `validate:"eqfield=A"`
+		}{"x", "y"}, []string{"B eqfield"}},
+		{"eqfield equal", struct {
+			A string
+			B string `validate:"eqfield=A"`
+		}{"x", "x"}, nil},
+		{"eqfield pointer sibling", struct {
+			A *int
+			B int `validate:"eqfield=A"`
+		}{intPtr(2), 2}, nil},
+		{"eqfield nil sibling", struct {
+			A *string
+			B string `validate:"eqfield=A"`
+		}{nil, "x"}, []string{"B eqfield"}},
+		{"nefield", struct {
+			A string
+			B string `validate:"nefield=A"`
+		}{"x", "x"}, []string{"B nefield"}},
+		{"nefield nil sibling", struct {
+			A *string
+			B string `validate:"nefield=A"`
+		}{nil, "x"}, nil},
+		{"gtfield time", struct {
+			Start time.Time
+			End   time.Time `validate:"gtfield=Start"`
+		}{t1, t0}, []string{"End gtfield"}},
+		{"gtfield time later", struct {
+			Start time.Time
+			End   time.Time `validate:"gtfield=Start"`
+		}{t0, t1}, nil},
+		{"gtfield time equal", struct {
+			Start time.Time
+			End   time.Time `validate:"gtfield=Start"`
+		}{t0, t0}, []string{"End gtfield"}},
+		{"gtfield nil sibling", struct {
+			Start *time.Time
+			End   time.Time `validate:"gtfield=Start"`
+		}{nil, t0}, nil},
+		{"gtfield pointer sibling", struct {
+			Start *time.Time
+			End   *time.Time `validate:"gtfield=Start"`
+		}{&t1, &t0}, []string{"End gtfield"}},
+		{"gtefield equal", struct {
+			A int
+			B int `validate:"gtefield=A"`
+		}{2, 2}, nil},
+		{"ltfield", struct {
+			A int
+			B int `validate:"ltfield=A"`
+		}{2, 2}, []string{"B ltfield"}},
+		{"ltefield", struct {
+			A float64
+			B float64 `validate:"ltefield=A"`
+		}{1, 1.5}, []string{"B ltefield"}},
+
+		{"dive", struct {
+			L []string `validate:"max=2,dive,min=2"`
+		}{[]string{"ab", "c", "de"}}, []string{"L max", "L[1] min"}},
+		{"dive map", struct {
+			M map[string]int `validate:"dive,min=1"`
+		}{map[string]int{"a": 1, "b": -1}}, []string{`M["b"] min`}},
+		{"dive pointers", struct {
+			L []*int `validate:"dive,required"`
+		}{[]*int{nil, intPtr(0)}}, []string{"L[0] required"}},
+		{"dive skips empty elements", struct {
+			L []string `validate:"dive,email"`
+		}{[]string{"", "jo@example.com"}}, nil},
+
+		{"nested paths", struct {
+			Owner *user
+			Users []user
+			ByID  map[int]user
+		}{&user{}, []user{{"jo@example.com"}, {"jo"}}, map[int]user{7: {"x"}}},
+			[]string{"Owner.Email required", "Users[1].Email email", "ByID[7].Email email"}},
+		{"dash skips a struct", struct {
+			Owner user `validate:"-"`
+		}{}, nil},
+	}
+	for _, test := range tests {
+		got := problems(t, &Validator{}, test.value)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRuleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"unknown rule", struct {
+			S string `validate:"nosuch"`
+		}{"x"}, `validate: S: unknown rule "nosuch"`},
+		{"unknown rule after dive", struct {
+			L []string `validate:"dive,nosuch"`
+		}{[]string{"x"}}, `validate: L[0]: unknown rule "nosuch"`},
+		{"bad number", struct {
+			S string `validate:"min=abc"`
+		}{"x"}, `validate: S: rule min: "abc" isn't a number`},
+		{"min on bool", struct {
+			B bool `validate:"min=1"`
+		}{true}, "validate: B: rule min: can't be used on bool"},
+		{"email on int", struct {
+			N int `validate:"email"`
+		}{1}, "validate: N: rule email: can't be used on int"},
+		{"bad regexp", struct {
+			S string `validate:"regexp=("`
+		}{"x"}, "validate: S: rule regexp: error parsing regexp"},
+		{"no such field", struct {
+			S string `validate:"eqfield=Z"`
+		}{"x"}, "validate: S: rule eqfield: no field Z"},
+		{"incomparable fields", struct {
+			A int
+			S string `validate:"gtfield=A"`
+		}{1, "x"}, "validate: S: rule gtfield: can't compare string with int"},
+	}
+	for _, test := range tests {
+		err := (&Validator{}).Struct(test.value)
+		if _, ok := err.(*StructError); ok || err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error starting with %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	v := &Validator{}
+	v.Register("email", func(f *Field, param string) (string, error) {
+		if !strings.HasSuffix(f.Value.String(), "@example.com") {
+			return "must be an example.com address", nil
+		}
+		return "", nil
+	})
+	v.Register("even", func(f *Field, param string) (string, error) {
+		if f.Value.Int()%2 != 0 {
+			return "must be even", nil
+		}
+		return "", nil
+	})
+	value := struct {
+		A string `validate:"email"`
+		B string `validate:"email"`
+		N int    `validate:"even"`
+	}{"jo@example.com", "jo@example.org", 3}
+	want := []string{"B email", "N even"}
+	if got := problems(t, v, value); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Other Validators keep the built in rule
+	if got := problems(t, &Validator{}, struct {
+		B string `validate:"email"`
+	}{value.B}); got != nil {
+		t.Errorf("a new Validator got %q, want nothing", got)
+	}
+}
+
+func TestTag(t *testing.T) {
+	value := struct {
+		S string `check:"required" validate:"min=3"`
+	}{}
+	want := []string{"S required"}
+	if got := problems(t, &Validator{Tag: "check"}, value); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStructError(t *testing.T) {
+	value := &struct {
+		Name  string `validate:"min=3"`
+		Users []user
+	}{"Jo", []user{{"jo@example.com"}, {}}}
+	err := Struct(value)
+	want := "validate: 2 problem(s): Name: must be at least 3 characters long; Users[1].Email: is required"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	fields := err.(*StructError).Fields
+	if fields[0].Param != "3" || fields[0].Message != "must be at least 3 characters long" {
+		t.Errorf("first problem is %+v", fields[0])
+	}
+	if err := fields[1].Error(); err != "validate: Users[1].Email: is required" {
+		t.Errorf("second problem is %s", err)
+	}
+}